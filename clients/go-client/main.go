@@ -6,6 +6,7 @@ import (
     "log"
     "net"
     "os"
+    "strings"
     "time"
     "github.com/joho/godotenv"
 )
@@ -24,12 +25,16 @@ func loadEnvVariables() (string, string) {
     return serverHost, serverPort
 }
 
-// Helper function to continuously listen for server messages and echo them
+// Helper function to continuously listen for server messages and echo them.
+// Heartbeat PINGs are answered so the server doesn't drop the connection as idle.
 func listenForMessages(conn net.Conn) {
     scanner := bufio.NewScanner(conn)
     for scanner.Scan() {
         message := scanner.Text()
         fmt.Println("Received from server:", message)
+        if strings.HasPrefix(message, "PING") {
+            fmt.Fprintln(conn, "PONG")
+        }
     }
 
     // Handle error when scanner stops (usually EOF/connection closed)
@@ -8,11 +8,17 @@ const SERVER_HOST = process.env.SERVER_HOST || 'localhost';   // Default to 'loc
 const SERVER_PORT = process.env.SERVER_PORT || 8080;          // Default to port 8080
 const TIMEOUT = Number(process.env.TIMEOUT) || 5000;          // Default 5 seconds timeout (5000ms)
 
-// Function to listen for messages from the server
+// Function to listen for messages from the server, answering heartbeat PINGs
+// so the server doesn't drop the connection as idle
 function listenForMessages(socket) {
     socket.on('data', function(data) {
         const message = data.toString().trim();
         console.log(`Received from server: ${message}`);
+        for (const line of message.split('\n')) {
+            if (line.startsWith('PING')) {
+                socket.write('PONG\n');
+            }
+        }
     });
 
     socket.on('timeout', function() {
@@ -11,7 +11,8 @@ load_dotenv()
 SERVER_HOST = os.getenv("SERVER_HOST", "localhost")  # Default to localhost if not set
 SERVER_PORT = int(os.getenv("SERVER_PORT", 8080))     # Default to port 8080 if not set
 
-# Function to continuously listen for messages
+# Function to continuously listen for messages, answering heartbeat PINGs so
+# the server doesn't drop the connection as idle
 def listen_for_messages(sock):
     while True:
         try:
@@ -20,6 +21,9 @@ def listen_for_messages(sock):
                 print("Server closed the connection.")
                 return
             print(f"Received from server: {data.strip()}")
+            for line in data.splitlines():
+                if line.startswith("PING"):
+                    sock.sendall(b"PONG\n")
         except socket.error as e:
             print(f"Connection error: {e}")
             return
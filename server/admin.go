@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Whether an admin token has been configured; ADMIN is refused without one
+func adminEnabled() bool {
+	return config.AdminToken != ""
+}
+
+func validAdminToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+}
+
+// Handle the operator commands:
+//
+//	ADMIN <token> SNAPSHOTS        -> SNAPSHOTS <count>, SNAPSHOT <tick> lines, END SNAPSHOTS
+//	ADMIN <token> ROLLBACK <tick>  -> OK: Rolled back to tick <tick>
+func handleAdminCommand(state *GameState, args []string) string {
+	if !adminEnabled() {
+		return "ERROR: ADMIN is disabled\n"
+	}
+	if len(args) < 2 {
+		return "ERROR: ADMIN requires a token and an operation\n"
+	}
+	if !validAdminToken(args[0]) {
+		return "ERROR: Invalid admin token\n"
+	}
+
+	switch args[1] {
+	case "SNAPSHOTS":
+		ticks, err := listSnapshots()
+		if err != nil {
+			return fmt.Sprintf("ERROR: %v\n", err)
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "SNAPSHOTS %d\n", len(ticks))
+		for _, tick := range ticks {
+			fmt.Fprintf(&b, "SNAPSHOT %d\n", tick)
+		}
+		b.WriteString("END SNAPSHOTS\n")
+		return b.String()
+
+	case "ROLLBACK":
+		if len(args) < 3 {
+			return "ERROR: ROLLBACK requires a tick\n"
+		}
+		tick, err := strconv.Atoi(args[2])
+		if err != nil {
+			return fmt.Sprintf("ERROR: Invalid tick %s\n", args[2])
+		}
+		if err := rollbackTo(state, tick); err != nil {
+			return fmt.Sprintf("ERROR: %v\n", err)
+		}
+		return fmt.Sprintf("OK: Rolled back to tick %d\n", tick)
+
+	default:
+		return fmt.Sprintf("ERROR: Unknown admin operation %s\n", args[1])
+	}
+}
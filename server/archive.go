@@ -0,0 +1,212 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	archiveFormat = "surge-protocol-world"
+	// Bump whenever the archive layout changes incompatibly. Version 1
+	// archives held raw API keys and are rekeyed when read.
+	archiveVersion = 2
+)
+
+// A whole world in one portable file, independent of the storage backend.
+// Written as gzip compressed JSON.
+type worldArchive struct {
+	Format        string            `json:"format"`
+	Version       int               `json:"version"`
+	ServerVersion string            `json:"server_version"`
+	ExportedAt    int64             `json:"exported_at"` // Unix time
+	Config        Config            `json:"config"`      // Config of the exporting server, without secrets
+	State         *GameState        `json:"state"`       // Tick, players, next robot ID, map seed and key salt
+	Cells         []snapshotCell    `json:"cells"`       // Every non-empty cell with all its layers
+	Events        []json.RawMessage `json:"events"`      // The command log, for replays
+}
+
+// The export subcommand: write the stored world to an archive file. Like
+// replay, it leaves the stored data as it is, so it must already be migrated.
+//
+//	server export [-out world.json.gz]
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "", "archive file to write (default: world-<tick>.json.gz)")
+	flags.Parse(args)
+
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	requirePersistentStorage("export")
+	openStorageReadOnly()
+
+	state, err := store.LoadGameState()
+	if err != nil {
+		log.Fatalf("Failed to load game state: %v", err)
+	}
+	if state == nil {
+		log.Fatalf("No saved world to export.")
+	}
+	grid, err = store.LoadGrid(config.GridWidth, config.GridHeight)
+	if err != nil {
+		log.Fatalf("Failed to load game grid: %v", err)
+	}
+	events, err := store.LoadEvents()
+	if err != nil {
+		log.Fatalf("Failed to load command log: %v", err)
+	}
+
+	archive := worldArchive{
+		Format:        archiveFormat,
+		Version:       archiveVersion,
+		ServerVersion: serverVersion,
+		ExportedAt:    time.Now().Unix(),
+		Config:        config,
+		State:         state,
+		Cells:         captureSnapshot(state).Cells,
+	}
+	archive.Config.AdminToken = ""
+	for _, event := range events {
+		archive.Events = append(archive.Events, event)
+	}
+
+	if *out == "" {
+		*out = fmt.Sprintf("world-%d.json.gz", state.Tick)
+	}
+	if err := writeArchive(*out, &archive); err != nil {
+		log.Fatalf("Failed to write %s: %v", *out, err)
+	}
+	log.Printf("Exported tick %d with %d players, %d cells and %d logged events to %s.",
+		state.Tick, len(state.Players), len(archive.Cells), len(archive.Events), *out)
+}
+
+// The import subcommand: replace the stored world with an archive's
+//
+//	server import -in world.json.gz [-force]
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	in := flags.String("in", "", "archive file to read")
+	force := flags.Bool("force", false, "replace a world that is already stored")
+	flags.Parse(args)
+
+	if *in == "" {
+		log.Fatalf("import requires -in <archive file>")
+	}
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	requirePersistentStorage("import")
+
+	archive, err := readArchive(*in)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", *in, err)
+	}
+	if archive.Config.GridWidth != config.GridWidth || archive.Config.GridHeight != config.GridHeight {
+		log.Fatalf("The archive's grid is %dx%d but config.json says %dx%d; set grid_width and grid_height to match.",
+			archive.Config.GridWidth, archive.Config.GridHeight, config.GridWidth, config.GridHeight)
+	}
+
+	initStorage()
+	existing, err := store.LoadGameState()
+	if err != nil {
+		log.Fatalf("Failed to check for an existing world: %v", err)
+	}
+	if existing != nil && !*force {
+		log.Fatalf("Storage already holds a world at tick %d; pass -force to replace it.", existing.Tick)
+	}
+
+	grid = newEmptyGrid(config.GridWidth, config.GridHeight)
+	for _, c := range archive.Cells {
+		if c.X < 0 || c.X >= config.GridWidth || c.Y < 0 || c.Y >= config.GridHeight {
+			log.Fatalf("The archive has a cell outside the grid at (%d, %d).", c.X, c.Y)
+		}
+		grid[c.X][c.Y] = c.Cell
+	}
+	if archive.State.Players == nil {
+		archive.State.Players = make(map[string]Player)
+	}
+	events := make([][]byte, len(archive.Events))
+	for i, event := range archive.Events {
+		events[i] = event
+	}
+
+	if err := store.Reset(); err != nil {
+		log.Fatalf("Failed to clear storage: %v", err)
+	}
+	if err := store.SaveWorld(archive.State, grid, events); err != nil {
+		log.Fatalf("Failed to save imported world: %v", err)
+	}
+	log.Printf("Imported tick %d with %d players, %d cells and %d logged events from %s.",
+		archive.State.Tick, len(archive.State.Players), len(archive.Cells), len(events), *in)
+}
+
+// The in-memory store only lives as long as the process, so an export would
+// always be empty and an import would be thrown away on exit
+func requirePersistentStorage(command string) {
+	if config.Storage == "memory" {
+		log.Fatalf("%s needs persistent storage; set storage to \"redis\" in config.json.", command)
+	}
+}
+
+func writeArchive(filename string, archive *worldArchive) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	zw := gzip.NewWriter(file)
+	if err := json.NewEncoder(zw).Encode(archive); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func readArchive(filename string) (*worldArchive, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	archive := &worldArchive{}
+	if err := json.NewDecoder(zr).Decode(archive); err != nil {
+		return nil, fmt.Errorf("decoding archive: %w", err)
+	}
+	if archive.Format != archiveFormat {
+		return nil, fmt.Errorf("not a world archive")
+	}
+	if archive.Version < 1 || archive.Version > archiveVersion {
+		return nil, fmt.Errorf("archive version %d is not supported (expected %d)", archive.Version, archiveVersion)
+	}
+	if archive.State == nil {
+		return nil, fmt.Errorf("archive has no game state")
+	}
+
+	if archive.Version == 1 {
+		hashWorldKeys(archive.State, archive.Cells)
+		for i, event := range archive.Events {
+			rekeyed, err := hashEventKey(event, archive.State.KeySalt)
+			if err != nil {
+				return nil, fmt.Errorf("rewriting logged event %d: %w", i, err)
+			}
+			archive.Events[i] = rekeyed
+		}
+		archive.Version = archiveVersion
+	}
+	return archive, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// One entry in the durable command log. Together with the seed of the
+// initial map, the log is enough to rebuild the world at any tick.
+//
+// Tick is the tick the event belongs to: joins and scans carry the tick that
+// is about to be resolved, orders the tick that resolved them. Rolling back
+// to tick T therefore drops exactly the events after T.
+type CommandEvent struct {
+	Tick   int    `json:"tick"`
+	Type   string `json:"type"`             // "join", "scan" or "order"
+	Player string `json:"player"`           // ID of the acting player
+	Robot  int    `json:"robot"`            // Robot created, scanned with, or ordered; 0 if none was found
+	Name   string `json:"name,omitempty"`   // join: the new player's name
+	X      int    `json:"x,omitempty"`      // join: spawn the robot was placed on
+	Y      int    `json:"y,omitempty"`      // join: spawn the robot was placed on
+	Energy int    `json:"energy,omitempty"` // scan: energy charged
+	Order  string `json:"order,omitempty"`  // order: the order as committed
+}
+
+var (
+	eventsMu      sync.Mutex
+	pendingEvents [][]byte // Encoded events not yet written with a tick commit
+)
+
+// Queue an event to be written with the next tick commit, so the log and the
+// saved world always agree
+func recordEvent(event CommandEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event.Type, err)
+		return
+	}
+
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	pendingEvents = append(pendingEvents, data)
+}
+
+// Take every queued event, leaving the queue empty
+func takePendingEvents() [][]byte {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+
+	events := pendingEvents
+	pendingEvents = nil
+	return events
+}
+
+// Put events back at the front of the queue after a failed write
+func requeueEvents(events [][]byte) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	pendingEvents = append(events, pendingEvents...)
+}
+
+// Log the orders a tick resolved, in the order resolveOrders applied them
+func recordOrders(tick int, results map[string][]OrderResult) {
+	playerIDs := make([]string, 0, len(results))
+	for playerID := range results {
+		playerIDs = append(playerIDs, playerID)
+	}
+	sort.Strings(playerIDs)
+
+	for _, playerID := range playerIDs {
+		for _, result := range results[playerID] {
+			recordEvent(CommandEvent{Tick: tick, Type: "order", Player: playerID, Robot: result.RobotID, Order: result.Order})
+		}
+	}
+}
+
+// Read and decode the whole command log
+func loadCommandLog() ([]CommandEvent, error) {
+	entries, err := store.LoadEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]CommandEvent, 0, len(entries))
+	for i, entry := range entries {
+		var event CommandEvent
+		if err := json.Unmarshal(entry, &event); err != nil {
+			return nil, fmt.Errorf("decoding command log entry %d: %w", i, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Drop every logged event after the given tick, along with any not yet
+// written
+func truncateCommandLog(tick int) error {
+	takePendingEvents()
+
+	events, err := loadCommandLog()
+	if err != nil {
+		return err
+	}
+
+	keep := len(events)
+	for keep > 0 && events[keep-1].Tick > tick {
+		keep--
+	}
+	if keep == len(events) {
+		return nil
+	}
+	return store.TruncateEvents(keep)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// The game state and grid belong to the engine goroutine (gameLoop). Other
+// goroutines never touch them directly; they hand the engine a function to
+// run with the world instead.
+var (
+	engineRequests = make(chan func(state *GameState))
+	engineDone     chan struct{} // Closed once the engine has stopped
+)
+
+// Start the engine on the given world. Closing the returned channel stops it
+// between ticks.
+func startEngine(state *GameState) chan<- struct{} {
+	stop := make(chan struct{})
+	engineDone = make(chan struct{})
+	go gameLoop(state, stop, engineDone)
+	return stop
+}
+
+// Run fn on the engine and wait for it to finish. Reports false, without
+// running fn, if the engine has already stopped.
+func withWorld(fn func(state *GameState)) bool {
+	finished := make(chan struct{})
+	request := func(state *GameState) {
+		defer close(finished)
+		fn(state)
+	}
+
+	select {
+	case engineRequests <- request:
+	case <-engineDone:
+		return false
+	}
+	<-finished
+	return true
+}
+
+// The configured length of a tick
+func tickInterval() time.Duration {
+	return time.Duration(config.TickDuration * float64(time.Second))
+}
+
+var (
+	tickCommits  = make(map[string]bool) // Players that have committed on time since the last tick
+	tickOverruns int                     // Ticks that took longer than their slot
+	nextTickAt   time.Time               // When the next tick is due
+)
+
+func scheduleNextTick(at time.Time) {
+	nextTickAt = at
+}
+
+// The last moment a COMMIT counts for the next tick: commit_margin seconds
+// before it is due
+func commitDeadline() time.Time {
+	return nextTickAt.Add(-time.Duration(config.CommitMargin * float64(time.Second)))
+}
+
+func markTickCommit(playerID string) {
+	tickCommits[playerID] = true
+}
+
+func clearTickCommits() {
+	tickCommits = make(map[string]bool)
+}
+
+// Whether every connected player has committed on time since the last tick. A COMMIT
+// with nothing staged counts, so a player can pass.
+func allPlayersCommitted(state *GameState) bool {
+	waiting := 0
+	for _, playerID := range connectedPlayers() {
+		if _, exists := state.Players[playerID]; !exists {
+			continue
+		}
+		waiting++
+		if !tickCommits[playerID] {
+			return false
+		}
+	}
+	return waiting > 0
+}
+
+// Log a tick that ran past the start of the next one. It is measured from
+// when it was due, so time spent waiting for the engine counts too.
+func measureTick(tick int, due time.Time, interval time.Duration) {
+	took := time.Since(due)
+	if took <= interval {
+		return
+	}
+	tickOverruns++
+	log.Printf("Tick %d took %v, overrunning its %v slot by %v (%d overruns so far)",
+		tick, took.Round(time.Millisecond), interval, (took - interval).Round(time.Millisecond), tickOverruns)
+}
+
+// Start a fresh interval from now, dropping any beat already waiting
+func restartTicker(ticker *time.Ticker, interval time.Duration) {
+	ticker.Reset(interval)
+	select {
+	case <-ticker.C:
+	default:
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Configure a small world with no limits once, since session goroutines from
+// one test may still be reading config as the next one starts. Per-tick
+// exports go to a temporary directory.
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+
+	config = defaultConfig()
+	config.TickDuration = 1
+	config.GridWidth, config.GridHeight = 20, 20
+	config.Storage = "memory"
+	config.HeartbeatInterval, config.IdleTimeout, config.WriteTimeout = 0, 0, 0
+	config.RateLimitPerIP, config.RateLimitPerKey, config.InitPlayerPerMinute = 0, 0, 0
+	config.MaxConnectionsPerIP = 0
+	config.SlowClientPolicy = "drop" // Clients flood commands without waiting for replies
+	initRateLimiters()
+
+	dir, err := os.MkdirTemp("", "surge-test")
+	if err != nil {
+		panic(err)
+	}
+	gameStateFile = filepath.Join(dir, "game_state.json")
+	gridImageFile = filepath.Join(dir, "grid_output.png")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// A fresh in-memory world with two spawns
+func newTestWorld(t *testing.T) *GameState {
+	t.Helper()
+
+	store = newMemoryStorage()
+	state := &GameState{Players: make(map[string]Player), Seed: 1}
+	initializeInMemoryGrid(state.Seed)
+	grid[2][2].Spawn = &Spawn{CooldownAmount: 5, EnergyRequired: 10}
+	grid[15][15].Spawn = &Spawn{CooldownAmount: 5, EnergyRequired: 10}
+	return state
+}
+
+// Connect a client over an in-memory pipe, draining everything the server
+// sends so the connection never backs up
+func connectTestClient(t *testing.T) net.Conn {
+	t.Helper()
+
+	client, server := net.Pipe()
+	connWG.Add(1)
+	go handleConnection(server)
+	go io.Copy(io.Discard, bufio.NewReader(client))
+	return client
+}
+
+func TestConcurrentClientsDuringTicks(t *testing.T) {
+	state := newTestWorld(t)
+	stop := startEngine(state)
+
+	const clients = 8
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn := connectTestClient(t)
+			defer conn.Close()
+
+			fmt.Fprintf(conn, "INIT_PLAYER player%d\n", i)
+			// Keep hammering the world for longer than one tick
+			deadline := time.Now().Add(1500 * time.Millisecond)
+			for time.Now().Before(deadline) {
+				for _, line := range []string{"SCAN", fmt.Sprintf("COMMAND MOVE %d %d", i, i), "QUEUE", "COMMIT", "STATUS", "CANCEL ALL"} {
+					if _, err := fmt.Fprintln(conn, line); err != nil {
+						t.Errorf("client %d: %v", i, err)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var players, tick int
+	withWorld(func(state *GameState) {
+		players, tick = len(state.Players), state.Tick
+	})
+	if players != clients {
+		t.Errorf("got %d players, want %d", players, clients)
+	}
+	if tick < 1 {
+		t.Errorf("no tick ran while clients were connected")
+	}
+
+	close(stop)
+	<-engineDone
+	connWG.Wait()
+}
+
+func TestWithWorldAfterEngineStops(t *testing.T) {
+	state := newTestWorld(t)
+	stop := startEngine(state)
+
+	ran := false
+	if !withWorld(func(*GameState) { ran = true }) || !ran {
+		t.Fatalf("request was not run on a running engine")
+	}
+
+	close(stop)
+	<-engineDone
+
+	ran = false
+	if withWorld(func(*GameState) { ran = true }) || ran {
+		t.Errorf("request was run after the engine stopped")
+	}
+}
+
+// A session bound to the given player, whose output is discarded
+func bindTestSession(t *testing.T, playerID string) {
+	t.Helper()
+
+	server, client := net.Pipe()
+	go io.Copy(io.Discard, client)
+	sess := registerSession(server)
+	bindSession(sess, playerID)
+	t.Cleanup(func() {
+		unregisterSession(sess)
+		closeSession(sess, "test finished")
+		client.Close()
+	})
+}
+
+// Stage an order for a player and commit it
+func stageAndCommit(state *GameState, playerID, order string) string {
+	player := state.Players[playerID]
+	player.Commands = append(player.Commands, order)
+	state.Players[playerID] = player
+	return commitCommands(state, playerID)
+}
+
+// The scheduler is driven directly: the next tick is placed well ahead of or
+// behind the clock, and ticks run when the test calls runTick
+func TestAdvanceWhenAllCommitted(t *testing.T) {
+	state := newTestWorld(t)
+	for _, playerID := range []string{"alice", "bob"} {
+		state.Players[playerID] = Player{ID: playerID, Commands: []string{}}
+		bindTestSession(t, playerID)
+	}
+	clearTickCommits()
+	scheduleNextTick(time.Now().Add(time.Hour))
+
+	stageAndCommit(state, "alice", "MOVE 3 3")
+	if allPlayersCommitted(state) {
+		t.Fatalf("advanced before every player committed")
+	}
+
+	// A commit with nothing staged passes
+	commitCommands(state, "bob")
+	if !allPlayersCommitted(state) {
+		t.Fatalf("did not advance once every player committed")
+	}
+
+	// Each tick needs fresh commits
+	runTick(state)
+	if allPlayersCommitted(state) {
+		t.Errorf("advanced again without new commits")
+	}
+
+	// A late commit is not one for this tick
+	config.CommitMargin = 0.5
+	defer func() { config.CommitMargin = 0 }()
+	scheduleNextTick(time.Now().Add(100 * time.Millisecond))
+	commitCommands(state, "alice")
+	commitCommands(state, "bob")
+	if allPlayersCommitted(state) {
+		t.Errorf("late commits counted towards advancing early")
+	}
+}
+
+func TestLateCommits(t *testing.T) {
+	config.CommitMargin = 0.5
+	defer func() {
+		config.CommitMargin = 0
+		config.LateCommitPolicy = "rollover"
+		config.MaxCommandsPerTick = 32
+	}()
+
+	state := newTestWorld(t)
+	state.Players["p"] = Player{ID: "p", Commands: []string{}}
+
+	scheduleNextTick(time.Now().Add(time.Hour))
+	if reply := stageAndCommit(state, "p", "MOVE 3 3"); reply != "OK: Commands committed for tick 1\n" {
+		t.Fatalf("on time commit: got %q", reply)
+	}
+
+	// The deadline falls half a second before the tick, which is now past
+	scheduleNextTick(time.Now().Add(100 * time.Millisecond))
+	if reply := stageAndCommit(state, "p", "MOVE 4 4"); !strings.HasPrefix(reply, "OK: Commands committed for tick 2 ROLLED_OVER ") {
+		t.Fatalf("late commit: got %q", reply)
+	}
+
+	config.LateCommitPolicy = "reject"
+	if reply := stageAndCommit(state, "p", "MOVE 5 5"); !strings.HasPrefix(reply, "ERROR: LATE_COMMIT ") {
+		t.Fatalf("rejected commit: got %q", reply)
+	}
+
+	// Once tick 1 resolves, the rolled over order is due on tick 2
+	runTick(state)
+	player := state.Players["p"]
+	if len(player.Committed) != 1 || player.Committed[0] != "MOVE 4 4" {
+		t.Errorf("committed after tick 1: got %q, want the rolled over order", player.Committed)
+	}
+	if len(player.Commands) != 1 || player.Commands[0] != "MOVE 5 5" {
+		t.Errorf("staged after a rejected commit: got %q", player.Commands)
+	}
+
+	// Promotion never leaves more orders due than one tick allows
+	config.MaxCommandsPerTick = 2
+	player.Committed = nil
+	player.RolledOver = []string{"MOVE 1 1", "MOVE 2 2", "MOVE 3 3"}
+	state.Players["p"] = player
+	promoteRolledOver(state)
+	if got := state.Players["p"].Committed; len(got) != 2 {
+		t.Errorf("promoted past the limit: got %q", got)
+	}
+}
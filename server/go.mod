@@ -2,13 +2,18 @@ module surge_protocol
 
 go 1.20
 
-require github.com/go-redis/redis/v8 v8.11.5
+require (
+	github.com/alicebob/miniredis/v2 v2.33.0
+	github.com/fogleman/gg v1.3.0
+	github.com/go-redis/redis/v8 v8.11.5
+	golang.org/x/net v0.30.0
+)
 
 require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/fogleman/gg v1.3.0 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	golang.org/x/image v0.21.0 // indirect
-	golang.org/x/net v0.30.0 // indirect
 )
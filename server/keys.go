@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+)
+
+// API keys are only ever seen by the client they were issued to. Inside the
+// server a player is identified by a salted hash of their key, which is what
+// the game state, storage, logs and exports hold. The salt is per world and
+// saved with it, so a key hashes to the same player ID after a restart.
+
+// Generate a new API key for a player
+func generateApiKey() string {
+	return randomHex(16)
+}
+
+// Generate the salt a new world hashes API keys with
+func newKeySalt() string {
+	return randomHex(16)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Error reading random bytes: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// The player ID an API key maps to in a world with the given salt
+func hashApiKey(salt, key string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// The player an API key belongs to, if any
+func playerForKey(state *GameState, key string) (string, bool) {
+	playerID := hashApiKey(state.KeySalt, key)
+	_, exists := state.Players[playerID]
+	return playerID, exists
+}
+
+// Worlds saved before keys were hashed are keyed by the raw API keys. These
+// rewrite their parts with the world's salt, for the schema migration and for
+// importing old archives.
+
+// Rekey a saved world's players and cells, giving it a salt if it has none
+func hashWorldKeys(state *GameState, cells []snapshotCell) {
+	if state.KeySalt == "" {
+		state.KeySalt = newKeySalt()
+	}
+
+	players := make(map[string]Player, len(state.Players))
+	for key, player := range state.Players {
+		player.ID = hashApiKey(state.KeySalt, key)
+		players[player.ID] = player
+	}
+	state.Players = players
+
+	for _, c := range cells {
+		hashCellKeys(c.Cell, state.KeySalt)
+	}
+}
+
+// Rekey the owners in a cell, reporting whether it had any
+func hashCellKeys(cell *GridCell, salt string) bool {
+	changed := false
+	if cell != nil && cell.Robot != nil && cell.Robot.Owner != "" {
+		cell.Robot.Owner = hashApiKey(salt, cell.Robot.Owner)
+		changed = true
+	}
+	if cell != nil && cell.PowerLink != nil && cell.PowerLink.BuiltBy != "" {
+		cell.PowerLink.BuiltBy = hashApiKey(salt, cell.PowerLink.BuiltBy)
+		changed = true
+	}
+	return changed
+}
+
+// Rekey a logged event, which is passed and returned JSON encoded
+func hashEventKey(data []byte, salt string) ([]byte, error) {
+	var event CommandEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+	event.Player = hashApiKey(salt, event.Player)
+	return json.Marshal(event)
+}
@@ -1,12 +1,12 @@
 package main
 
 import (
-	"encoding/hex"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"math/rand"
 	"net"
 	"net/http"
 	"os"
@@ -16,19 +16,23 @@ import (
 	"time"
 
 	"github.com/fogleman/gg"
-	"github.com/go-redis/redis/v8"
 	"golang.org/x/net/context"
 )
 
 const pngSquareSize = 15
 
 var (
-	rdb    *redis.Client
 	ctx    = context.Background()
 	mu     sync.Mutex
-	conns  = make(map[net.Conn]struct{})
+	conns  = make(map[net.Conn]*Session)
 	config Config
 	grid   [][]*GridCell // In-memory grid to store game state
+
+	playerConns = make(map[string]map[net.Conn]*Session) // playerID -> authenticated connections
+	connWG      sync.WaitGroup                           // Running connection handlers
+
+	gameStateFile = "/app/shared/game_state.json" // Exported after every tick and served over HTTP
+	gridImageFile = "/app/shared/grid_output.png" // Drawn after every tick
 )
 
 // Draw the grid and export it as a PNG file
@@ -96,11 +100,85 @@ func drawSquare(dc *gg.Context, x, y int, symbol string, r, g, b, textR, textG,
 
 // Config struct for reading JSON configuration
 type Config struct {
-	TickDuration     int    `json:"tick_duration"` // In seconds
-	ServerPort       string `json:"server_port"`
-	GridWidth        int    `json:"grid_width"`
-	GridHeight       int    `json:"grid_height"`
-	IsDevEnvironment bool   `json:"is_dev_environment"`
+	TickDuration         float64 `json:"tick_duration"` // In seconds; fractions such as 0.25 are allowed
+	ServerPort           string  `json:"server_port"`
+	GridWidth            int     `json:"grid_width"`
+	GridHeight           int     `json:"grid_height"`
+	IsDevEnvironment     bool    `json:"is_dev_environment"`
+	Seed                 int64   `json:"seed"`                   // Seed for a newly generated world, 0 to pick one at random
+	AdvanceWhenCommitted bool    `json:"advance_when_committed"` // Start the next tick early once every connected player has committed
+	CommitMargin         float64 `json:"commit_margin"`          // Seconds before each tick that the commit deadline falls
+	LateCommitPolicy     string  `json:"late_commit_policy"`     // "rollover" or "reject" for commits after the deadline
+	ScanRadius           int     `json:"scan_radius"`            // Cells visible around a robot with SCAN
+	ScanEnergyCost       int     `json:"scan_energy_cost"`       // Energy a robot spends per SCAN
+	WebSocketPath        string  `json:"websocket_path"`         // HTTP path of the WebSocket gateway
+	Storage              string  `json:"storage"`                // "redis" or "memory"
+	RedisAddr            string  `json:"redis_addr"`             // host:port of the Redis server
+	RestartDelay         int     `json:"restart_delay"`          // Seconds of downtime announced in SHUTDOWN, 0 if unknown
+	ShutdownTimeout      int     `json:"shutdown_timeout"`       // Seconds to wait for clients to receive SHUTDOWN
+
+	// TLS for the game and HTTP ports; both stay plaintext unless a
+	// certificate and key are given
+	TLSCertFile          string `json:"tls_cert_file"`
+	TLSKeyFile           string `json:"tls_key_file"`
+	TLSClientCAFile      string `json:"tls_client_ca_file"`      // CA that signs trusted bot host certificates
+	TLSRequireClientCert bool   `json:"tls_require_client_cert"` // Reject game clients without a valid certificate
+
+	HeartbeatInterval int `json:"heartbeat_interval"` // Seconds of client silence before the server sends PING, 0 to disable
+	IdleTimeout       int `json:"idle_timeout"`       // Seconds of client silence before disconnecting, 0 to disable
+	WriteTimeout      int `json:"write_timeout"`      // Seconds a single write may block, 0 to disable
+
+	OutboundQueueSize int    `json:"outbound_queue_size"` // Messages buffered per connection
+	SlowClientPolicy  string `json:"slow_client_policy"`  // "disconnect" or "drop" when a client's queue is full
+
+	// Abuse limits; a rate or cap of 0 turns that limit off
+	RateLimitPerIP      float64 `json:"rate_limit_per_ip"`      // Commands per second from one IP
+	RateBurstPerIP      int     `json:"rate_burst_per_ip"`      // Commands an IP may send in a burst
+	RateLimitPerKey     float64 `json:"rate_limit_per_key"`     // Commands per second acting on one player
+	RateBurstPerKey     int     `json:"rate_burst_per_key"`     // Commands a player may send in a burst
+	InitPlayerPerMinute float64 `json:"init_player_per_minute"` // INIT_PLAYER calls per minute from one IP
+	InitPlayerBurst     int     `json:"init_player_burst"`      // INIT_PLAYER calls an IP may make in a burst
+	MaxConnectionsPerIP int     `json:"max_connections_per_ip"` // Simultaneous connections from one IP
+	MaxCommandsPerTick  int     `json:"max_commands_per_tick"`  // Staged, committed and rolled-over commands per player per tick
+
+	// World snapshots for rolling back; both 0 turns snapshots off
+	SnapshotKeepRecent int    `json:"snapshot_keep_recent"` // Snapshots kept of the most recent ticks
+	SnapshotEvery      int    `json:"snapshot_every"`       // Older snapshots are kept for every this many ticks
+	AdminToken         string `json:"admin_token"`          // Token for ADMIN commands, which are off when empty
+}
+
+// Values used for anything config.json leaves out
+func defaultConfig() Config {
+	return Config{
+		ScanRadius:      3,
+		ScanEnergyCost:  1,
+		WebSocketPath:   "/ws",
+		Storage:         "redis",
+		RedisAddr:       "redis:6379",
+		RestartDelay:    60,
+		ShutdownTimeout: 5,
+
+		LateCommitPolicy: "rollover",
+
+		HeartbeatInterval: 30,
+		IdleTimeout:       90,
+		WriteTimeout:      10,
+
+		OutboundQueueSize: 64,
+		SlowClientPolicy:  "disconnect",
+
+		RateLimitPerIP:      20,
+		RateBurstPerIP:      40,
+		RateLimitPerKey:     10,
+		RateBurstPerKey:     20,
+		InitPlayerPerMinute: 5,
+		InitPlayerBurst:     3,
+		MaxConnectionsPerIP: 8,
+		MaxCommandsPerTick:  32,
+
+		SnapshotKeepRecent: 10,
+		SnapshotEvery:      100,
+	}
 }
 
 // Grid object types
@@ -120,6 +198,7 @@ type PowerLink struct {
 }
 
 type Robot struct {
+	ID           int    `json:"id"`            // Unique robot identifier, used to address orders
 	Owner        string `json:"owner"`         // Player who owns the robot
 	Health       int    `json:"health"`        // Health of the robot
 	Energy       int    `json:"energy"`        // Energy of the robot
@@ -146,69 +225,96 @@ func loadConfig() error {
 		return err
 	}
 
+	config = defaultConfig()
 	err = json.Unmarshal(byteValue, &config)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Configuration loaded: TickDuration = %d, ServerPort = %s", config.TickDuration, config.ServerPort)
+	if config.TickDuration <= 0 {
+		return fmt.Errorf("tick_duration must be greater than 0, got %g", config.TickDuration)
+	}
+	if config.OutboundQueueSize < 1 {
+		return fmt.Errorf("outbound_queue_size must be at least 1, got %d", config.OutboundQueueSize)
+	}
+	switch config.SlowClientPolicy {
+	case "drop", "disconnect":
+	default:
+		return fmt.Errorf("unknown slow_client_policy %q; use \"drop\" or \"disconnect\"", config.SlowClientPolicy)
+	}
+	if err := checkTLSSettings(); err != nil {
+		return err
+	}
+	switch config.LateCommitPolicy {
+	case "rollover", "reject":
+	default:
+		return fmt.Errorf("unknown late_commit_policy %q; use \"rollover\" or \"reject\"", config.LateCommitPolicy)
+	}
+	if config.CommitMargin < 0 || config.CommitMargin >= config.TickDuration {
+		return fmt.Errorf("commit_margin must be at least 0 and less than tick_duration (%gs), got %gs", config.TickDuration, config.CommitMargin)
+	}
+
+	log.Printf("Configuration loaded: TickDuration = %gs, ServerPort = %s", config.TickDuration, config.ServerPort)
 	return nil
 }
 
-// GameState struct, stored in Redis
+// GameState struct, persisted by the storage backend
 type GameState struct {
-	Tick    int               `json:"tick"`
-	Players map[string]Player `json:"players"` // Map of apiKey -> Player
+	Tick        int               `json:"tick"`
+	Players     map[string]Player `json:"players"`       // Map of player ID (API key hash) -> Player
+	NextRobotID int               `json:"next_robot_id"` // ID handed to the next robot created
+	Seed        int64             `json:"seed"`          // Seed the initial map was generated from, 0 if unknown
+	KeySalt     string            `json:"key_salt"`      // Salt API keys are hashed with into player IDs
 }
 
 type Player struct {
-	ApiKey   string   `json:"api_key"`
-	Name     string   `json:"name"`
-	Commands []string `json:"commands"` // Buffered commands
+	ID         string      `json:"id"` // Salted hash of the player's API key
+	Name       string      `json:"name"`
+	Commands   []string    `json:"commands"`    // Buffered commands
+	Committed  []string    `json:"committed"`   // Orders committed for resolution on the next tick
+	RolledOver []string    `json:"rolled_over"` // Orders committed after the deadline, resolved on the tick after next
+	CreatedAt  int64       `json:"created_at"`  // Unix time the player was initialized
+	LastSeen   int64       `json:"last_seen"`   // Unix time of the player's last command
+	Stats      PlayerStats `json:"stats"`
 }
 
-// Load or Initialize Game State from Redis
+// Running totals kept for each player
+type PlayerStats struct {
+	OrdersSucceeded int `json:"orders_succeeded"`
+	OrdersFailed    int `json:"orders_failed"`
+	Scans           int `json:"scans"`
+}
+
+// Load or Initialize Game State from storage
 func loadOrInitGameState() *GameState {
-	state := &GameState{}
-	result, err := rdb.Get(ctx, "game:state").Result()
-	if err == redis.Nil {
+	state, err := store.LoadGameState()
+	if err != nil {
+		log.Fatalf("Failed to load game state: %v", err)
+	}
+
+	if state == nil {
 		// If no game state is found, initialize
 		state = &GameState{
 			Tick:    0,
 			Players: make(map[string]Player),
+			KeySalt: newKeySalt(),
 		}
 		saveGameState(*state)
 		log.Println("Initialized new game state.")
-	} else if err != nil {
-		log.Fatalf("Failed to load game state from Redis: %v", err)
 	} else {
-		if err := json.Unmarshal([]byte(result), state); err != nil {
-			log.Fatalf("Failed to parse game state: %v", err)
-		}
-		log.Println("Loaded game state from Redis.")
+		log.Println("Loaded game state from storage.")
 	}
 	return state
 }
 
-// Save game state in Redis
+// Save game state to storage
 func saveGameState(state GameState) {
-	data, _ := json.Marshal(state)
-	if err := rdb.Set(ctx, "game:state", data, 0).Err(); err != nil {
+	if err := store.SaveGameState(&state); err != nil {
 		log.Fatalf("Failed to store game state: %v", err)
 	}
 }
 
-// Generate a new API key for a player
-func generateApiKey() string {
-	key := make([]byte, 16)
-	_, err := rand.Read(key)
-	if err != nil {
-		log.Fatalf("Error generating API key: %v", err)
-	}
-	return hex.EncodeToString(key)
-}
-
-func createRobotForPlayer(apiKey string) error {
+func createRobotForPlayer(state *GameState, playerID string) error {
 	// Collect all spawn points
 	spawnLocations := make([][2]int, 0)
 	for x := 0; x < config.GridWidth; x++ {
@@ -225,39 +331,37 @@ func createRobotForPlayer(apiKey string) error {
 		return fmt.Errorf("no available spawn points")
 	}
 
-	// Select a random spawn point from the available spawn points
-	chosenSpawn := spawnLocations[rand.Intn(len(spawnLocations))]
+	// Select a random spawn point, the same one every time for this world
+	// and robot
+	rng := decisionRand(state.Seed, state.NextRobotID+1)
+	chosenSpawn := spawnLocations[rng.Intn(len(spawnLocations))]
 	x, y := chosenSpawn[0], chosenSpawn[1]
 
-	// Create the robot and assign it to the chosen spawn location
+	newRobot := placeRobot(state, playerID, x, y)
+	recordEvent(CommandEvent{Tick: state.Tick + 1, Type: "join", Player: playerID, Name: state.Players[playerID].Name, Robot: newRobot.ID, X: x, Y: y})
+
+	log.Printf("Robot %d created for player %s at spawn point (%d, %d)", newRobot.ID, playerID, x, y)
+	return nil
+}
+
+// Create a robot for the player at the given position
+func placeRobot(state *GameState, playerID string, x, y int) *Robot {
+	state.NextRobotID++
 	newRobot := &Robot{
-		Owner:        apiKey,
+		ID:           state.NextRobotID,
+		Owner:        playerID,
 		Health:       100, // Default health
 		Energy:       50,  // Default energy
 		QueuedAction: "",  // No action queued initially
 	}
 	grid[x][y].Robot = newRobot
-
-	// Save the updated grid cell to Redis
-	key := fmt.Sprintf("grid:%d:%d", x, y)
-	data := map[string]interface{}{
-		"type":          "robot",
-		"owner":         newRobot.Owner,
-		"health":        newRobot.Health,
-		"energy":        newRobot.Energy,
-		"queued_action": newRobot.QueuedAction,
-	}
-	if err := rdb.HSet(ctx, key, data).Err(); err != nil {
-		log.Printf("Failed to save robot at spawn location (%d, %d): %v", x, y, err)
-		return err
-	}
-
-	log.Printf("Robot created for player %s at spawn point (%d, %d)", apiKey, x, y)
-	return nil
+	markDirty(x, y) // Saved with the next tick
+	return newRobot
 }
 
 // Parse commands from clients
-func parseCommand(conn net.Conn, input string, state *GameState) {
+func parseCommand(sess *Session, input string, state *GameState) {
+	conn := sess.Conn
 	parts := strings.Split(strings.TrimSpace(input), " ")
 	if len(parts) == 0 {
 		conn.Write([]byte("ERROR: Invalid command format\n"))
@@ -266,27 +370,68 @@ func parseCommand(conn net.Conn, input string, state *GameState) {
 
 	log.Printf("\n\nPARTS 0: %s\n\n", parts[0])
 
+	// Heartbeat replies are free; the connection's limits were charged before
+	// the command got here
+	if parts[0] != "PONG" {
+		if limit := checkKeyLimit(sess, parts, state); limit != "" {
+			conn.Write([]byte(fmt.Sprintf("ERROR: RATE_LIMITED %s\n", limit)))
+			return
+		}
+	}
+
+	// Any command acting on a player counts as seeing them
+	if playerID, _, ok := resolvePlayer(sess, parts[1:], state); ok {
+		touchPlayer(state, playerID)
+	}
+
 	helpString := `
 # COMMANDS:
 
 HELP
+VERSION
+PING [TOKEN]
 INIT_PLAYER <PLAYERNAME>
+AUTH <APIKEY>
+SCAN <APIKEY> [ROBOT_ID]   (alias LOOK)
 
 # QUEUEING COMMANDS FOR THIS TICK
+# (the API key may be omitted once the connection has sent AUTH)
 
 COMMAND <APIKEY> <COMMANDNAME> <PARAMETER1> <PARAMETER2>
 
+# INSPECTING AND EDITING STAGED COMMANDS
+
+STATUS <APIKEY>
+QUEUE <APIKEY>
+CANCEL <APIKEY> <INDEX|ALL>
+
 # SENDING YOUR COMMANDS FOR EXECUTION
 
-COMMIT <APIKEY>`
+COMMIT <APIKEY>
+
+# OPERATORS
+
+ADMIN <TOKEN> SNAPSHOTS
+ADMIN <TOKEN> ROLLBACK <TICK>`
 
 	switch parts[0] {
 	case "HELP":
 		conn.Write([]byte(helpString))
 		return
 
+	case "PING":
+		// Echo any token back so clients can match replies and measure latency
+		conn.Write([]byte(strings.TrimSpace("PONG "+strings.Join(parts[1:], " ")) + "\n"))
+
+	case "PONG":
+		// Reply to a server heartbeat; receiving it already reset the idle timer
+
+	case "VERSION":
+		conn.Write([]byte(buildBanner()))
+
 	case "INIT_PLAYER":
 		apiKey := generateApiKey()
+		playerID := hashApiKey(state.KeySalt, apiKey)
 
 		if len(parts) < 2 {
 			conn.Write([]byte("ERROR: Invalid INIT_PLAYER format: INIT_PLAYER NAME\n"))
@@ -295,190 +440,211 @@ COMMIT <APIKEY>`
 
 		name := parts[1]
 
-		if _, exists := state.Players[apiKey]; exists {
+		if _, exists := state.Players[playerID]; exists {
 			conn.Write([]byte("ERROR: Player already exists\n"))
 			return
 		}
 
 		// Create a new player
-		newPlayer := Player{ApiKey: apiKey, Name: name, Commands: []string{}}
-		state.Players[apiKey] = newPlayer
+		now := time.Now().Unix()
+		newPlayer := Player{ID: playerID, Name: name, Commands: []string{}, CreatedAt: now, LastSeen: now}
+		state.Players[playerID] = newPlayer
+		markPlayerDirty(playerID)
 
 		// Create a robot at a random spawn location for the new player
-		if err := createRobotForPlayer(apiKey); err != nil {
+		if err := createRobotForPlayer(state, playerID); err != nil {
 			conn.Write([]byte("ERROR: Could not create robot for player\nREPORT TO ADMINISTRATOR."))
 			return
 		}
 
+		// The connection that created the player is authenticated as it
+		bindSession(sess, playerID)
+
 		conn.Write([]byte("OK: Player initialized and robot created at a spawn point\n"))
+		// The only time the key itself is ever sent or seen
 		conn.Write([]byte(fmt.Sprintf("API_KEY FOR %s: %s\n", name, apiKey)))
 
-	case "COMMAND":
-		if len(parts) < 3 {
-			conn.Write([]byte("ERROR: COMMAND requires API key and action\n"))
+	case "AUTH":
+		if len(parts) < 2 {
+			conn.Write([]byte("ERROR: AUTH requires API key\n"))
 			return
 		}
-		apiKey := parts[1]
-		if player, exists := state.Players[apiKey]; !exists {
+		if playerID, exists := playerForKey(state, parts[1]); !exists {
 			conn.Write([]byte("ERROR: Player not found\n"))
 		} else {
-			action := parts[2:] // Store the rest as a command
-			commandStr := formatCommand(action)
-			player.Commands = append(player.Commands, commandStr)
-			state.Players[apiKey] = player
-			conn.Write([]byte("OK: Command staged\n"))
+			bindSession(sess, playerID)
+			conn.Write([]byte(fmt.Sprintf("OK: Authenticated as %s\n", state.Players[playerID].Name)))
 		}
 
-	case "COMMIT":
-		if len(parts) < 2 {
-			conn.Write([]byte("ERROR: COMMIT requires API key\n"))
+	case "SCAN", "LOOK":
+		playerID, args, ok := resolvePlayer(sess, parts[1:], state)
+		if !ok {
+			conn.Write([]byte("ERROR: SCAN requires API key\n"))
 			return
 		}
-		apiKey := parts[1]
-		if player, exists := state.Players[apiKey]; !exists {
-			conn.Write([]byte("ERROR: Player not found\n"))
-		} else {
-			// Execute commands
-			executeCommands(player.Commands)
-			player.Commands = []string{} // Clear the command queue once executed
-			state.Players[apiKey] = player
-			conn.Write([]byte("OK: Commands committed\n"))
+		report, err := scanAroundRobot(state, playerID, args)
+		if err != nil {
+			conn.Write([]byte(fmt.Sprintf("ERROR: %v\n", err)))
+			return
+		}
+		conn.Write([]byte(report))
+
+	case "STATUS":
+		playerID, _, ok := resolvePlayer(sess, parts[1:], state)
+		if !ok {
+			conn.Write([]byte("ERROR: STATUS requires API key\n"))
+			return
+		}
+		conn.Write([]byte(buildStatus(state, playerID)))
+
+	case "QUEUE":
+		playerID, _, ok := resolvePlayer(sess, parts[1:], state)
+		if !ok {
+			conn.Write([]byte("ERROR: QUEUE requires API key\n"))
+			return
+		}
+		conn.Write([]byte(buildQueue(state, playerID)))
+
+	case "CANCEL":
+		playerID, args, ok := resolvePlayer(sess, parts[1:], state)
+		if !ok || len(args) == 0 {
+			conn.Write([]byte("ERROR: CANCEL requires API key and an index or ALL\n"))
+			return
+		}
+		cancelled, err := cancelCommands(state, playerID, args[0])
+		if err != nil {
+			conn.Write([]byte(fmt.Sprintf("ERROR: %v\n", err)))
+			return
+		}
+		conn.Write([]byte(fmt.Sprintf("OK: Cancelled %d command(s)\n", cancelled)))
+
+	case "COMMAND":
+		playerID, action, ok := resolvePlayer(sess, parts[1:], state)
+		if !ok {
+			if len(parts) < 3 {
+				conn.Write([]byte("ERROR: COMMAND requires API key and action\n"))
+			} else {
+				conn.Write([]byte("ERROR: Player not found\n"))
+			}
+			return
+		}
+		if len(action) == 0 {
+			conn.Write([]byte("ERROR: COMMAND requires an action\n"))
+			return
+		}
+		player := state.Players[playerID]
+		if config.MaxCommandsPerTick > 0 && len(player.Commands)+len(player.Committed)+len(player.RolledOver) >= config.MaxCommandsPerTick {
+			conn.Write([]byte(fmt.Sprintf("ERROR: COMMAND_LIMIT at most %d commands per tick\n", config.MaxCommandsPerTick)))
+			return
+		}
+		commandStr := formatCommand(action) // Store the rest as a command
+		player.Commands = append(player.Commands, commandStr)
+		state.Players[playerID] = player
+		conn.Write([]byte("OK: Command staged\n"))
+
+	case "COMMIT":
+		playerID, _, ok := resolvePlayer(sess, parts[1:], state)
+		if !ok {
+			if len(parts) < 2 {
+				conn.Write([]byte("ERROR: COMMIT requires API key\n"))
+			} else {
+				conn.Write([]byte("ERROR: Player not found\n"))
+			}
+			return
 		}
+		conn.Write([]byte(commitCommands(state, playerID)))
+
+	case "ADMIN":
+		conn.Write([]byte(handleAdminCommand(state, parts[1:])))
 
 	default:
 		conn.Write([]byte(fmt.Sprintf("ERROR: Unknown command %s\n", parts[0])))
 	}
 }
 
-func formatCommand(parts []string) string {
-	return fmt.Sprintf("%s", parts)
+// Record that a player was just active
+func touchPlayer(state *GameState, playerID string) {
+	player := state.Players[playerID]
+	player.LastSeen = time.Now().Unix()
+	state.Players[playerID] = player
+	markPlayerDirty(playerID)
 }
 
-func executeCommands(commands []string) {
-	for _, cmd := range commands {
-		log.Printf("Executing command: %s", cmd)
-		// Actual game logic to execute command goes here
-	}
+func formatCommand(parts []string) string {
+	return strings.Join(parts, " ")
 }
 
-// Send tick message to all connected clients
-func sendTickMessage(tick int) {
+// Send tick message to all connected clients, with the deadline for
+// committing to the next tick in Unix milliseconds:
+//
+//	TICK <tick> DEADLINE <unix ms>
+func sendTickMessage(tick int, deadline time.Time) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	message := fmt.Sprintf("TICK %d\n", tick)
+	message := fmt.Sprintf("TICK %d DEADLINE %d\n", tick, deadline.UnixMilli())
 	log.Printf("Sending tick %d to %d clients.", tick, len(conns))
 
-	for conn := range conns {
+	for conn, sess := range conns {
 		_, err := conn.Write([]byte(message))
 		if err != nil {
 			log.Printf("Failed to send tick to client %v: %v. Closing connection.", conn.RemoteAddr(), err)
-			conn.Close()
+			closeSession(sess, "write failed: "+err.Error())
 			delete(conns, conn)
 		}
 	}
 }
 
-func loadGridFromRedis() [][]*GridCell {
-	loadedGrid := make([][]*GridCell, config.GridWidth)
-	for x := 0; x < config.GridWidth; x++ {
-		loadedGrid[x] = make([]*GridCell, config.GridHeight)
-	}
-
-	iter := rdb.Scan(ctx, 0, "grid:*", 0).Iterator()
-	for iter.Next(ctx) {
-		key := iter.Val()
-
-		var x, y int
-		_, err := fmt.Sscanf(key, "grid:%d:%d", &x, &y)
-		if err != nil {
-			log.Printf("Failed to parse grid coordinates from key %s: %v", key, err)
-			continue
-		}
-
-		cellData, err := rdb.HGetAll(ctx, key).Result()
-		if err != nil {
-			log.Printf("Failed to load cell data from Redis for %s: %v", key, err)
-			continue
-		}
-
-		cell := &GridCell{}
-		cellType := cellData["type"]
-
-		switch cellType {
-		case "spawn":
-			cell.Spawn = &Spawn{
-				CooldownUntil:  atoi(cellData["cooldown_until"]),
-				CooldownAmount: atoi(cellData["cooldown_amount"]),
-				EnergyRequired: atoi(cellData["energy_required"]),
-			}
-		case "power_node":
-			cell.PowerNode = &PowerNode{
-				EnergyProducedPerTick: atoi(cellData["energy_produced_per_tick"]),
-			}
-		case "power_link":
-			cell.PowerLink = &PowerLink{
-				BuiltBy: cellData["built_by"],
-				Health:  atoi(cellData["health"]),
-			}
-		case "robot":
-			cell.Robot = &Robot{
-				Owner:        cellData["owner"],
-				Health:       atoi(cellData["health"]),
-				Energy:       atoi(cellData["energy"]),
-				QueuedAction: cellData["queued_action"],
-			}
-		}
-
-		loadedGrid[x][y] = cell
-	}
-
-	if err := iter.Err(); err != nil {
-		log.Fatalf("Error iterating through Redis keys: %v", err)
-	}
-
-	log.Println("Game grid with entities successfully loaded from Redis.")
-	return loadedGrid
-}
-
 // Helper function to convert string to int
 func atoi(s string) int {
 	i, _ := strconv.Atoi(s)
 	return i
 }
 
-func initializeGameGrid() {
-	// Check if the grid has already been initialized in Redis
-	exists, err := rdb.Exists(ctx, "grid-initialized").Result()
+func initializeGameGrid(state *GameState) {
+	// Check if the grid has already been initialized in storage
+	exists, err := store.GridInitialized()
 	if err != nil {
-		log.Fatalf("Error checking grid initialization in Redis: %v", err)
+		log.Fatalf("Error checking grid initialization: %v", err)
 	}
 
-	if exists > 0 {
-		// Grid exists in Redis; load it into memory
-		log.Println("Loading existing game grid from Redis.")
-		grid = loadGridFromRedis()
+	if exists {
+		// Grid exists in storage; load it into memory
+		log.Println("Loading existing game grid from storage.")
+		grid, err = store.LoadGrid(config.GridWidth, config.GridHeight)
+		if err != nil {
+			log.Fatalf("Failed to load game grid: %v", err)
+		}
+		log.Println("Game grid with entities successfully loaded.")
+		if config.Seed != 0 && config.Seed != state.Seed {
+			log.Printf("Ignoring configured seed %d; the stored world was generated with seed %d.", config.Seed, state.Seed)
+		}
 	} else {
-		// Grid does not exist; initialize a new one in memory and save it
-		log.Println("No grid found in Redis; initializing new game grid.")
-		initializeInMemoryGrid()
-		saveGridToRedis()
-
-		// Mark grid as initialized in Redis
-		if err := rdb.Set(ctx, "grid-initialized", 1, 0).Err(); err != nil {
-			log.Fatalf("Failed to mark grid as initialized in Redis: %v", err)
+		// Grid does not exist; initialize a new one in memory and save it,
+		// recording the seed with the world so it can be generated again
+		log.Println("No grid found in storage; initializing new game grid.")
+		state.Seed = config.Seed
+		if state.Seed == 0 {
+			state.Seed = time.Now().UnixNano()
+		}
+		log.Printf("Generating the world from seed %d.", state.Seed)
+		saveGameState(*state)
+		initializeInMemoryGrid(state.Seed)
+		if err := store.SaveGrid(grid); err != nil {
+			log.Fatalf("Failed to save new game grid: %v", err)
 		}
 	}
 }
 
-func initializeInMemoryGrid() {
+func initializeInMemoryGrid(seed int64) {
+	rng := mapRand(seed)
 	grid = make([][]*GridCell, config.GridWidth)
 	for x := 0; x < config.GridWidth; x++ {
 		grid[x] = make([]*GridCell, config.GridHeight)
 		for y := 0; y < config.GridHeight; y++ {
 			cell := &GridCell{}
 
-			randVal := rand.Float64()
+			randVal := rng.Float64()
 			switch {
 			case randVal < (0.001): // 5% chance for a Spawn object
 				cell.Spawn = &Spawn{
@@ -498,135 +664,208 @@ func initializeInMemoryGrid() {
 	log.Println("In-memory game grid initialized with various entity types.")
 }
 
-func saveGridToRedis() {
-	for x := 0; x < config.GridWidth; x++ {
-		for y := 0; y < config.GridHeight; y++ {
-			cell := grid[x][y]
-			if cell == nil {
+// The engine: the only goroutine that touches the game state and grid. It
+// sends "TICK" every tick_duration seconds and, between ticks, runs the
+// requests other goroutines submit with withWorld. Closing stop ends the loop
+// between ticks; done is closed once the loop has exited.
+//
+// Ticks keep a fixed cadence however long each one takes to run. A tick that
+// overruns its slot is logged; the next one then starts late, and any further
+// beats it ran into are dropped rather than run back to back. With
+// advance_when_committed set, a tick starts as soon as every connected player
+// has committed, and the next one is due a full tick_duration later.
+func gameLoop(state *GameState, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	interval := tickInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	scheduleNextTick(time.Now().Add(interval))
+	for {
+		select {
+		case due := <-ticker.C:
+			scheduleNextTick(due.Add(interval))
+			runTick(state)
+			measureTick(state.Tick, due, interval)
+		case request := <-engineRequests:
+			request(state)
+			if !config.AdvanceWhenCommitted || !allPlayersCommitted(state) {
 				continue
 			}
-
-			key := fmt.Sprintf("grid:%d:%d", x, y)
-			data := make(map[string]interface{})
-
-			if cell.Spawn != nil {
-				data["type"] = "spawn"
-				data["cooldown_until"] = cell.Spawn.CooldownUntil
-				data["cooldown_amount"] = cell.Spawn.CooldownAmount
-				data["energy_required"] = cell.Spawn.EnergyRequired
-			} else if cell.PowerNode != nil {
-				data["type"] = "power_node"
-				data["energy_produced_per_tick"] = cell.PowerNode.EnergyProducedPerTick
-			} else if cell.PowerLink != nil {
-				data["type"] = "power_link"
-				data["built_by"] = cell.PowerLink.BuiltBy
-				data["health"] = cell.PowerLink.Health
-			} else if cell.Robot != nil {
-				data["type"] = "robot"
-				data["owner"] = cell.Robot.Owner
-				data["health"] = cell.Robot.Health
-				data["energy"] = cell.Robot.Energy
-				data["queued_action"] = cell.Robot.QueuedAction
-			}
-
-			if len(data) > 0 {
-				err := rdb.HSet(ctx, key, data).Err()
-				if err != nil {
-					log.Printf("Failed to save cell at (%d, %d): %v", x, y, err)
-				}
-			}
+			log.Printf("Every connected player has committed; advancing early.")
+			// The fresh interval and the deadline sent with this tick start at
+			// the same instant, so the next beat lands on the deadline
+			started := time.Now()
+			restartTicker(ticker, interval)
+			scheduleNextTick(started.Add(interval))
+			runTick(state)
+			measureTick(state.Tick, started, interval)
+		case <-stop:
+			log.Printf("Tick loop stopped after tick %d", state.Tick)
+			return
 		}
 	}
-	log.Println("In-memory game grid with entities saved to Redis.")
 }
 
-// Game tick process - Sends "TICK X" every tick_duration seconds
-func gameLoop(state *GameState) {
-	for {
-		time.Sleep(time.Duration(config.TickDuration) * time.Second)
-		state.Tick++
-		log.Printf("Tick %d", state.Tick)
+// Advance the world by one tick
+func runTick(state *GameState) {
+	state.Tick++
+	clearTickCommits()
+	log.Printf("Tick %d", state.Tick)
 
-		sendTickMessage(state.Tick)
+	// Resolve everything committed since the last tick
+	results := resolveOrders(state)
+	recordOrders(state.Tick, results)
+	promoteRolledOver(state)
 
-		// Store the tick count in Redis
-		saveGameState(*state)
-		saveGridToRedis()
-		// Export the game state to JSON
-		if err := exportGameStateToJSON("/app/shared/game_state.json", state); err != nil {
-			log.Fatalf("Failed to export game state to JSON: %v", err)
-		}
+	sendTickMessage(state.Tick, commitDeadline())
+	sendTickReports(state, results)
 
-		// Draw the grid to a PNG file
-		if err := drawGrid("/app/shared/grid_output.png"); err != nil {
-			log.Fatalf("Failed to draw grid: %v", err)
-		}
+	// Store the tick count and the cells that changed together
+	commitTick(state)
+	snapshotTick(state)
+	// Export the game state to JSON
+	if err := exportGameStateToJSON(gameStateFile, state); err != nil {
+		log.Fatalf("Failed to export game state to JSON: %v", err)
+	}
+
+	// Draw the grid to a PNG file
+	if err := drawGrid(gridImageFile); err != nil {
+		log.Fatalf("Failed to draw grid: %v", err)
 	}
 }
 
-// Handle incoming client connections
-func handleConnection(conn net.Conn, state *GameState) {
+// Handle incoming client connections. The caller adds the connection to
+// connWG before starting this, so shutdown can't miss it.
+func handleConnection(conn net.Conn) {
 	log.Printf("New client connected: %v", conn.RemoteAddr())
 
-	mu.Lock()
-	conns[conn] = struct{}{}
-	mu.Unlock()
+	defer connWG.Done()
 
-	defer func() {
+	ip := remoteIP(conn)
+	if !admitConnection(ip) {
+		log.Printf("Refusing %v: too many connections from %s", conn.RemoteAddr(), ip)
+		conn.Write([]byte("ERROR: TOO_MANY_CONNECTIONS\n"))
 		conn.Close()
-		mu.Lock()
-		delete(conns, conn)
-		mu.Unlock()
-		log.Printf("Client disconnected: %v", conn.RemoteAddr())
+		return
+	}
+	defer releaseConnection(ip)
+
+	sess := registerSession(&deadlineConn{Conn: conn})
+	conn = sess.Conn
+
+	// Let the client know what it is talking to before it sends anything
+	conn.Write([]byte(buildBanner()))
+
+	defer func() {
+		unregisterSession(sess)
+		log.Printf("Client disconnected: %v (%s)", conn.RemoteAddr(), sessionCloseReason(sess))
 	}()
 
+	lastActivity := time.Now()
 	for {
+		conn.SetReadDeadline(nextReadDeadline(lastActivity))
+
 		buf := make([]byte, 1024)
 		length, err := conn.Read(buf)
 		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if idleTimedOut(lastActivity) {
+					closeSession(sess, "idle timeout")
+					return
+				}
+				// Quiet but not yet idle: prompt the client to prove it is alive
+				conn.Write([]byte(fmt.Sprintf("PING %d\n", time.Now().Unix())))
+				continue
+			}
+			if err == io.EOF {
+				closeSession(sess, "client closed connection")
+			} else {
+				closeSession(sess, "read failed: "+err.Error())
+			}
 			return
 		}
+		lastActivity = time.Now()
+
+		// Not logged, since commands carry API keys
 		input := string(buf[:length])
-		log.Printf("Received: %s", input)
-		parseCommand(conn, input, state)
+		if parts := strings.Fields(input); len(parts) > 0 && parts[0] != "PONG" {
+			if limit := checkConnectionLimits(sess, parts); limit != "" {
+				conn.Write([]byte(fmt.Sprintf("ERROR: RATE_LIMITED %s\n", limit)))
+				continue
+			}
+		}
+		handled := withWorld(func(state *GameState) {
+			parseCommand(sess, input, state)
+		})
+		if !handled {
+			conn.Write([]byte("ERROR: SHUTTING_DOWN\n"))
+		}
+	}
+}
+
+// When the next read should give up: at the next heartbeat, or when the
+// client will have been idle too long, whichever comes first
+func nextReadDeadline(lastActivity time.Time) time.Time {
+	deadline := time.Time{} // No deadline
+	if config.HeartbeatInterval > 0 {
+		deadline = time.Now().Add(time.Duration(config.HeartbeatInterval) * time.Second)
+	}
+	if config.IdleTimeout > 0 {
+		idleAt := lastActivity.Add(time.Duration(config.IdleTimeout) * time.Second)
+		if deadline.IsZero() || idleAt.Before(deadline) {
+			deadline = idleAt
+		}
 	}
+	return deadline
+}
+
+func idleTimedOut(lastActivity time.Time) bool {
+	return config.IdleTimeout > 0 && time.Since(lastActivity) >= time.Duration(config.IdleTimeout)*time.Second
 }
 
-// Start the TCP server that listens for client connections
-func startServer(state *GameState) {
+// Start the TCP server that listens for client connections. Connections are
+// accepted in the background until the returned listener is closed.
+func startServer() net.Listener {
+	tlsConfig, err := loadTLSConfig(true)
+	if err != nil {
+		log.Fatalf("Failed to configure TLS: %v", err)
+	}
+
 	address := fmt.Sprintf(":%s", config.ServerPort)
-	listener, err := net.Listen("tcp", address)
+	var listener net.Listener
+	if tlsConfig != nil {
+		listener, err = tls.Listen("tcp", address, tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", address)
+	}
 	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
-	log.Printf("Server listening on port %s", config.ServerPort)
+	log.Printf("Server listening on port %s (TLS: %t)", config.ServerPort, tlsConfig != nil)
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Println("Error accepting connection:", err)
-			continue
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				log.Println("Stopped accepting connections.")
+				return
+			}
+			if err != nil {
+				log.Println("Error accepting connection:", err)
+				continue
+			}
+			connWG.Add(1)
+			go handleConnection(conn)
 		}
-		go handleConnection(conn, state)
-	}
-}
+	}()
 
-// Initialize Redis connection
-func initRedis() {
-	rdb = redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
-	})
-	if _, err := rdb.Ping(ctx).Result(); err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
-	}
-	log.Println("Connected to Redis.")
+	return listener
 }
 
 func nukeEverything() {
-	// Create a context
-	ctx := context.Background()
-	// Execute FLUSHDB command
-	err := rdb.FlushDB(ctx).Err()
+	// Wipe the storage backend
+	err := store.Reset()
 	if err != nil {
 		fmt.Println("Error:", err)
 	} else {
@@ -664,24 +903,61 @@ func exportGameStateToJSON(filename string, state *GameState) error {
 	return nil
 }
 
-// Serve the exported JSON file over HTTP on port 80
-func serveJSONFile(filename string) {
+// Serve the exported JSON file over HTTP on port 80 in the background
+func serveJSONFile(filename string) *http.Server {
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, filename)
 	})
 
-	log.Println("Serving JSON file on port 80...")
-	if err := http.ListenAndServe(":80", nil); err != nil {
-		log.Fatalf("Failed to start HTTP server: %v", err)
+	// Browsers can't present client certificates, so only the game port checks them
+	tlsConfig, err := loadTLSConfig(false)
+	if err != nil {
+		log.Fatalf("Failed to configure TLS: %v", err)
 	}
+
+	server := &http.Server{Addr: ":80", TLSConfig: tlsConfig}
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			log.Println("Serving JSON file on port 80 over TLS...")
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			log.Println("Serving JSON file on port 80...")
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start HTTP server: %v", err)
+		}
+	}()
+
+	return server
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		case "migrate":
+			runMigrate(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		}
+	}
+
 	if err := loadConfig(); err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	initRedis() // Initialize Redis connection
+	initRateLimiters()
+
+	initStorage() // Connect to the configured storage backend
 
 	if config.IsDevEnvironment {
 		log.Println("Dev Environment Detected...")
@@ -690,14 +966,22 @@ func main() {
 		log.Println("Production Environment Detected...")
 	}
 
+	recoverPartialTick()
+
 	state := loadOrInitGameState() // Load or initialize game state
 
-	initializeGameGrid()
+	initializeGameGrid(state)
+
+	stopEngine := startEngine(state) // From here on only the engine touches the world
 
-	go gameLoop(state) // Start the tick system loop
+	// Browser clients speak the game protocol over WebSocket on the HTTP port
+	serveWebSocketGateway()
 
 	// Serve the game state JSON file over HTTP on port 80
-	go serveJSONFile("/app/shared/game_state.json")
+	httpServer := serveJSONFile(gameStateFile)
+
+	listener := startServer() // Start the TCP server to accept client connections
 
-	startServer(state) // Start the TCP server to accept client connections
+	reason := waitForShutdownSignal()
+	shutdown(state, reason, listener, httpServer, stopEngine)
 }
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// One step in upgrading the Redis data to a newer format. Run reports how
+// many keys it changed, or with dryRun set, would change without writing.
+type redisMigration struct {
+	description string
+	run         func(s *redisStorage, dryRun bool) (int, error)
+}
+
+// Every migration in order. The schema version stored under schema-version
+// is the number of steps applied, so steps must only ever be appended.
+var redisMigrations = []redisMigration{
+	{"store grid cells as one hash field per layer", migrateLegacyCells},
+	{"split game:state into world:meta and player records", migrateGameStateBlob},
+	{"replace raw API keys with salted hashes", migrateHashApiKeys},
+}
+
+// Apply every migration newer than the stored schema version, recording the
+// version after each step. With dryRun set, only report what would change.
+func (s *redisStorage) Migrate(dryRun bool) error {
+	version, err := s.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if version == len(redisMigrations) {
+		log.Printf("Schema is up to date at version %d.", version)
+		return nil
+	}
+
+	for i := version; i < len(redisMigrations); i++ {
+		step := redisMigrations[i]
+		changed, err := step.run(s, dryRun)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", i+1, step.description, err)
+		}
+
+		if dryRun {
+			log.Printf("Migration %d would %s: %d keys to change.", i+1, step.description, changed)
+			continue
+		}
+		if err := s.client.Set(ctx, "schema-version", i+1, 0).Err(); err != nil {
+			return err
+		}
+		log.Printf("Migration %d applied, %s: %d keys changed.", i+1, step.description, changed)
+	}
+	return nil
+}
+
+func (s *redisStorage) PendingMigrations() (int, error) {
+	version, err := s.schemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	return len(redisMigrations) - version, nil
+}
+
+// The stored schema version, refusing data written by a newer server
+func (s *redisStorage) schemaVersion() (int, error) {
+	version, err := s.client.Get(ctx, "schema-version").Int()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+	if version > len(redisMigrations) {
+		return 0, fmt.Errorf("schema version %d is newer than this server supports (%d)", version, len(redisMigrations))
+	}
+	return version, nil
+}
+
+// Rewrite every legacy single-type cell hash in the layered format. Robots
+// without an ID are numbered on from the world's next robot ID, which is
+// saved back wherever the world keeps it.
+func migrateLegacyCells(s *redisStorage, dryRun bool) (int, error) {
+	legacy := make(map[string]*GridCell)
+	maxRobotID := 0
+	iter := s.client.Scan(ctx, 0, "grid:*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+
+		cellData, err := s.client.HGetAll(ctx, key).Result()
+		if err != nil {
+			return 0, fmt.Errorf("reading %s: %w", key, err)
+		}
+
+		var cell *GridCell
+		if _, isLegacy := cellData["type"]; isLegacy {
+			cell = legacyCellFromRedisHash(cellData)
+			legacy[key] = cell
+		} else if cell, err = cellFromRedisHash(cellData); err != nil {
+			return 0, fmt.Errorf("decoding %s: %w", key, err)
+		}
+		if cell.Robot != nil && cell.Robot.ID > maxRobotID {
+			maxRobotID = cell.Robot.ID
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("iterating through Redis keys: %w", err)
+	}
+	if dryRun {
+		return len(legacy), nil
+	}
+
+	nextRobotID, err := s.legacyNextRobotID()
+	if err != nil {
+		return 0, err
+	}
+	savedNextRobotID := nextRobotID
+	if maxRobotID > nextRobotID {
+		nextRobotID = maxRobotID
+	}
+
+	// Number the robots in key order, so the same world always migrates the same way
+	keys := make([]string, 0, len(legacy))
+	for key := range legacy {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pipe := s.client.TxPipeline()
+	for _, key := range keys {
+		cell := legacy[key]
+		if cell.Robot != nil && cell.Robot.ID == 0 {
+			nextRobotID++
+			cell.Robot.ID = nextRobotID
+		}
+		pipe.Del(ctx, key)
+		if !isEmptyCell(cell) {
+			pipe.HSet(ctx, key, cellToRedisHash(cell))
+		}
+	}
+	if nextRobotID != savedNextRobotID {
+		if err := s.queueLegacyNextRobotID(pipe, nextRobotID); err != nil {
+			return 0, err
+		}
+	}
+	// The marker that tracked this migration before schema versions
+	pipe.Del(ctx, "grid-format")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("rewriting legacy cells: %w", err)
+	}
+	return len(legacy), nil
+}
+
+// The next robot ID of a world that may predate world:meta, where it was a
+// field of the game:state blob
+func (s *redisStorage) legacyNextRobotID() (int, error) {
+	meta, err := s.client.HGet(ctx, "world:meta", "next_robot_id").Result()
+	if err == nil {
+		return atoi(meta), nil
+	} else if err != redis.Nil {
+		return 0, err
+	}
+
+	blob, err := s.legacyStateBlob()
+	if err != nil || blob == nil {
+		return 0, err
+	}
+	var value int
+	if raw, ok := blob["next_robot_id"]; ok {
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return 0, fmt.Errorf("parsing game state: %w", err)
+		}
+	}
+	return value, nil
+}
+
+// Queue saving the next robot ID wherever the world keeps it. The game:state
+// blob is rewritten field by field so nothing else in it changes.
+func (s *redisStorage) queueLegacyNextRobotID(pipe redis.Pipeliner, value int) error {
+	exists, err := s.client.Exists(ctx, "world:meta").Result()
+	if err != nil {
+		return err
+	}
+	if exists > 0 {
+		pipe.HSet(ctx, "world:meta", "next_robot_id", value)
+		return nil
+	}
+
+	blob, err := s.legacyStateBlob()
+	if err != nil || blob == nil {
+		return err
+	}
+	blob["next_robot_id"] = json.RawMessage(strconv.Itoa(value))
+	data, err := json.Marshal(blob)
+	if err != nil {
+		return err
+	}
+	pipe.Set(ctx, "game:state", data, 0)
+	return nil
+}
+
+// The game state saved in the game:state blob, or nil if there is none
+func (s *redisStorage) legacyGameState() (*GameState, error) {
+	data, err := s.client.Get(ctx, "game:state").Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	state := &GameState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing game state: %w", err)
+	}
+	return state, nil
+}
+
+// The fields of the game:state blob, or nil if there is none
+func (s *redisStorage) legacyStateBlob() (map[string]json.RawMessage, error) {
+	data, err := s.client.Get(ctx, "game:state").Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	blob := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &blob); err != nil {
+		return nil, fmt.Errorf("parsing game state: %w", err)
+	}
+	return blob, nil
+}
+
+// Split the single game:state blob that older versions saved into
+// world:meta and per-player records
+func migrateGameStateBlob(s *redisStorage, dryRun bool) (int, error) {
+	state, err := s.legacyGameState()
+	if err != nil || state == nil {
+		return 0, err
+	}
+	changed := len(state.Players) + 2 // The players, world:meta and game:state itself
+	if dryRun {
+		return changed, nil
+	}
+
+	pipe := s.client.TxPipeline()
+	if err := s.queueStateWrite(pipe, state); err != nil {
+		return 0, err
+	}
+	pipe.Del(ctx, "game:state", "world:tick")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// Give a world saved with raw API keys a salt, and rewrite its players, cell
+// owners, command log and snapshots with the hashed player IDs. Everything is
+// written in one MULTI/EXEC so no part is left keyed the old way.
+func migrateHashApiKeys(s *redisStorage, dryRun bool) (int, error) {
+	// A pending tick journal is keyed the old way too
+	if !dryRun {
+		if _, err := s.RecoverTick(); err != nil {
+			return 0, err
+		}
+	}
+
+	state, err := s.LoadGameState()
+	if err != nil {
+		return 0, err
+	}
+	if state == nil {
+		// A dry run of an older world, whose game:state blob and legacy
+		// cells the earlier steps would have converted
+		if state, err = s.legacyGameState(); err != nil || state == nil {
+			return 0, err
+		}
+	}
+	if state.KeySalt != "" {
+		return 0, nil
+	}
+	hashWorldKeys(state, nil)
+	changed := len(state.Players) + 1 // The players and world:meta
+
+	pipe := s.client.TxPipeline()
+	if err := s.queueStateWrite(pipe, state); err != nil {
+		return 0, err
+	}
+
+	iter := s.client.Scan(ctx, 0, "grid:*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		cellData, err := s.client.HGetAll(ctx, key).Result()
+		if err != nil {
+			return 0, fmt.Errorf("reading %s: %w", key, err)
+		}
+		var cell *GridCell
+		if _, legacy := cellData["type"]; legacy {
+			cell = legacyCellFromRedisHash(cellData)
+		} else if cell, err = cellFromRedisHash(cellData); err != nil {
+			return 0, fmt.Errorf("decoding %s: %w", key, err)
+		}
+		if hashCellKeys(cell, state.KeySalt) {
+			changed++
+			pipe.Del(ctx, key)
+			pipe.HSet(ctx, key, cellToRedisHash(cell))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("iterating through Redis keys: %w", err)
+	}
+
+	events, err := s.LoadEvents()
+	if err != nil {
+		return 0, err
+	}
+	for i, event := range events {
+		if events[i], err = hashEventKey(event, state.KeySalt); err != nil {
+			return 0, fmt.Errorf("rewriting logged event %d: %w", i, err)
+		}
+	}
+	if len(events) > 0 {
+		changed++
+		pipe.Del(ctx, "commandlog")
+		queueEventAppend(pipe, events)
+	}
+
+	ticks, err := s.SnapshotTicks()
+	if err != nil {
+		return 0, err
+	}
+	for _, tick := range ticks {
+		data, err := s.LoadSnapshot(tick)
+		if err != nil || data == nil {
+			continue
+		}
+		snapshot := &worldSnapshot{}
+		if err := json.Unmarshal(data, snapshot); err != nil {
+			return 0, fmt.Errorf("parsing snapshot of tick %d: %w", tick, err)
+		}
+		snapshot.State.KeySalt = state.KeySalt
+		hashWorldKeys(snapshot.State, snapshot.Cells)
+		if data, err = json.Marshal(snapshot); err != nil {
+			return 0, err
+		}
+		changed++
+		pipe.Set(ctx, fmt.Sprintf("snapshot:%d", tick), data, 0)
+	}
+
+	if dryRun {
+		pipe.Discard()
+		return changed, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// A Redis store on an in-process server, holding the given keys
+func newTestRedis(t *testing.T, strings map[string]string, hashes map[string]map[string]string) *redisStorage {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	for key, value := range strings {
+		server.Set(key, value)
+	}
+	for key, fields := range hashes {
+		for field, value := range fields {
+			server.HSet(key, field, value)
+		}
+	}
+
+	s, err := newRedisStorage(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.client.Close() })
+	return s
+}
+
+// A world as the original server saved it. It placed robots on spawns by
+// writing the robot's fields into the spawn's hash, then saved the spawn over
+// it without clearing it, so one hash holds both layers whichever type it
+// ended up with.
+func newBaselineRedis(t *testing.T) *redisStorage {
+	t.Helper()
+
+	spawn := map[string]string{"cooldown_until": "0", "cooldown_amount": "5", "energy_required": "10"}
+	robot := func(owner string) map[string]string {
+		return map[string]string{"owner": owner, "health": "100", "energy": "50", "queued_action": ""}
+	}
+	mixed := func(cellType string, layers ...map[string]string) map[string]string {
+		cell := map[string]string{"type": cellType}
+		for _, layer := range layers {
+			for field, value := range layer {
+				cell[field] = value
+			}
+		}
+		return cell
+	}
+
+	return newTestRedis(t,
+		map[string]string{
+			"game:state": `{"tick":3,"players":{` +
+				`"key-a":{"api_key":"key-a","name":"alice","commands":[]},` +
+				`"key-b":{"api_key":"key-b","name":"bob","commands":[]}}}`,
+		},
+		map[string]map[string]string{
+			"grid:5:5": mixed("robot", spawn, robot("key-a")),
+			"grid:6:6": mixed("spawn", spawn, robot("key-b")),
+			"grid:1:1": {"type": "power_node", "energy_produced_per_tick": "7"},
+		},
+	)
+}
+
+func TestMigrateBaselineWorld(t *testing.T) {
+	s := newBaselineRedis(t)
+
+	if err := s.Migrate(false); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	state, err := s.LoadGameState()
+	if err != nil || state == nil {
+		t.Fatalf("load state: %v %v", state, err)
+	}
+	if state.NextRobotID != 2 {
+		t.Errorf("next robot ID: got %d, want 2", state.NextRobotID)
+	}
+	loaded, err := s.LoadGrid(20, 20)
+	if err != nil {
+		t.Fatalf("load grid: %v", err)
+	}
+
+	for _, want := range []struct {
+		x, y  int
+		id    int
+		owner string
+	}{
+		{5, 5, 1, "key-a"},
+		{6, 6, 2, "key-b"},
+	} {
+		cell := loaded[want.x][want.y]
+		if cell == nil || cell.Spawn == nil || cell.Spawn.CooldownAmount != 5 || cell.Spawn.EnergyRequired != 10 {
+			t.Errorf("(%d, %d): spawn lost: %+v", want.x, want.y, cell)
+			continue
+		}
+		if cell.Robot == nil {
+			t.Errorf("(%d, %d): robot lost", want.x, want.y)
+			continue
+		}
+		if cell.Robot.ID != want.id || cell.Robot.Health != 100 || cell.Robot.Energy != 50 {
+			t.Errorf("(%d, %d): got robot %+v, want ID %d", want.x, want.y, *cell.Robot, want.id)
+		}
+		if owner, _ := playerForKey(state, want.owner); cell.Robot.Owner != owner {
+			t.Errorf("(%d, %d): robot owner %q is not the player for %s", want.x, want.y, cell.Robot.Owner, want.owner)
+		}
+	}
+
+	if node := loaded[1][1]; node == nil || node.PowerNode == nil || node.PowerNode.EnergyProducedPerTick != 7 || node.Robot != nil || node.Spawn != nil {
+		t.Errorf("(1, 1): got %+v, want just the power node", node)
+	}
+}
+
+// Each step's dry run sees the world as the earlier steps would leave it
+func TestDryRunHashApiKeysOnBaselineWorld(t *testing.T) {
+	s := newBaselineRedis(t)
+
+	changed, err := migrateHashApiKeys(s, true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	// Two players, world:meta and the two robots' cells
+	if changed != 5 {
+		t.Errorf("dry run: got %d keys to change, want 5", changed)
+	}
+	if keys, _ := s.client.Keys(ctx, "*").Result(); len(keys) != 4 {
+		t.Errorf("dry run wrote to Redis: %v", keys)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	moveEnergyCost   = 1   // Energy spent per cell moved
+	repairEnergyCost = 5   // Energy spent repairing a power link
+	linkMaxHealth    = 100 // Health of a fully repaired power link
+)
+
+// Outcome of a single committed order, reported back to its owner
+type OrderResult struct {
+	Order   string // The order as it was committed
+	RobotID int    // Robot the order was applied to, 0 if none could be found
+	OK      bool
+	Reason  string // Short machine-readable failure code, empty on success
+}
+
+// Where a robot currently sits in the grid
+type RobotLocation struct {
+	X, Y  int
+	Robot *Robot
+}
+
+// Collect every robot in the grid grouped by owner, ordered by robot ID
+func findRobots() map[string][]RobotLocation {
+	robots := make(map[string][]RobotLocation)
+	for x := 0; x < config.GridWidth; x++ {
+		for y := 0; y < config.GridHeight; y++ {
+			if cell := grid[x][y]; cell != nil && cell.Robot != nil {
+				robots[cell.Robot.Owner] = append(robots[cell.Robot.Owner], RobotLocation{X: x, Y: y, Robot: cell.Robot})
+			}
+		}
+	}
+
+	for _, owned := range robots {
+		sort.Slice(owned, func(i, j int) bool { return owned[i].Robot.ID < owned[j].Robot.ID })
+	}
+	return robots
+}
+
+// Resolve every player's committed orders against the grid. Players are
+// processed in player ID order so a tick always resolves the same way.
+func resolveOrders(state *GameState) map[string][]OrderResult {
+	results := make(map[string][]OrderResult)
+
+	playerIDs := make([]string, 0, len(state.Players))
+	for playerID, player := range state.Players {
+		if len(player.Committed) > 0 {
+			playerIDs = append(playerIDs, playerID)
+		}
+	}
+	sort.Strings(playerIDs)
+
+	robots := findRobots()
+	harvested := make(map[[2]int]bool) // Power nodes already drawn from this tick
+	for _, playerID := range playerIDs {
+		player := state.Players[playerID]
+		for _, order := range player.Committed {
+			result := executeOrder(robots[playerID], order, harvested)
+			if result.OK {
+				log.Printf("Player %s order %q on robot %d succeeded", playerID, order, result.RobotID)
+				player.Stats.OrdersSucceeded++
+			} else {
+				log.Printf("Player %s order %q failed: %s", playerID, order, result.Reason)
+				player.Stats.OrdersFailed++
+			}
+			results[playerID] = append(results[playerID], result)
+		}
+		player.Committed = []string{}
+		state.Players[playerID] = player
+		markPlayerDirty(playerID)
+	}
+
+	return results
+}
+
+// Hand a player's staged commands over for resolution. Before the commit
+// deadline they go to the next tick; after it, late_commit_policy either rolls
+// them over to the tick after or rejects them, leaving them staged:
+//
+//	OK: Commands committed for tick <tick>
+//	OK: Commands committed for tick <tick> ROLLED_OVER <ms late>
+//	ERROR: LATE_COMMIT <ms late>
+func commitCommands(state *GameState, playerID string) string {
+	player := state.Players[playerID]
+	late := time.Since(commitDeadline())
+
+	if late <= 0 {
+		player.Committed = append(player.Committed, player.Commands...)
+		player.Commands = []string{} // Clear the command queue once committed
+		state.Players[playerID] = player
+		markTickCommit(playerID)
+		return fmt.Sprintf("OK: Commands committed for tick %d\n", state.Tick+1)
+	}
+
+	if config.LateCommitPolicy == "reject" {
+		log.Printf("Player %s committed %v after the deadline; rejected", playerID, late.Round(time.Millisecond))
+		return fmt.Sprintf("ERROR: LATE_COMMIT %d\n", late.Milliseconds())
+	}
+	log.Printf("Player %s committed %v after the deadline; rolled over", playerID, late.Round(time.Millisecond))
+	player.RolledOver = append(player.RolledOver, player.Commands...)
+	player.Commands = []string{}
+	state.Players[playerID] = player
+	return fmt.Sprintf("OK: Commands committed for tick %d ROLLED_OVER %d\n", state.Tick+2, late.Milliseconds())
+}
+
+// After a tick resolves, orders that missed its deadline become due next
+func promoteRolledOver(state *GameState) {
+	for playerID, player := range state.Players {
+		if len(player.RolledOver) == 0 {
+			continue
+		}
+		player.Committed = append(player.Committed, player.RolledOver...)
+		player.RolledOver = nil
+		if limit := config.MaxCommandsPerTick; limit > 0 && len(player.Committed) > limit {
+			log.Printf("Player %s has %d orders due; dropping %d over the limit", playerID, len(player.Committed), len(player.Committed)-limit)
+			player.Committed = player.Committed[:limit]
+		}
+		state.Players[playerID] = player
+		markPlayerDirty(playerID)
+	}
+}
+
+// Locate the robot an order addresses. Orders may start with a robot ID;
+// without one they go to the player's lowest-numbered robot.
+func robotForOrder(owned []RobotLocation, fields []string) (*RobotLocation, []string, string) {
+	if len(fields) > 0 {
+		if id, err := strconv.Atoi(fields[0]); err == nil {
+			for i := range owned {
+				if owned[i].Robot.ID == id {
+					return &owned[i], fields[1:], ""
+				}
+			}
+			return nil, fields[1:], "unknown_robot"
+		}
+	}
+
+	if len(owned) == 0 {
+		return nil, fields, "no_robot"
+	}
+	return &owned[0], fields, ""
+}
+
+// Apply a single order to one of the player's robots and report how it went
+func executeOrder(owned []RobotLocation, order string, harvested map[[2]int]bool) OrderResult {
+	result := OrderResult{Order: order}
+
+	loc, fields, reason := robotForOrder(owned, strings.Fields(order))
+	if loc == nil {
+		result.Reason = reason
+		return result
+	}
+	result.RobotID = loc.Robot.ID
+
+	if len(fields) == 0 {
+		result.Reason = "missing_action"
+		return result
+	}
+
+	switch fields[0] {
+	case "MOVE":
+		result.Reason = moveRobot(loc, fields[1:])
+	case "HARVEST":
+		result.Reason = harvest(loc, harvested)
+	case "REPAIR":
+		result.Reason = repairLink(loc)
+	default:
+		result.Reason = "unknown_action"
+	}
+
+	result.OK = result.Reason == ""
+	return result
+}
+
+// MOVE <x> <y>: step one cell towards the target, x axis first
+func moveRobot(loc *RobotLocation, args []string) string {
+	if len(args) != 2 {
+		return "bad_arguments"
+	}
+	targetX, errX := strconv.Atoi(args[0])
+	targetY, errY := strconv.Atoi(args[1])
+	if errX != nil || errY != nil {
+		return "bad_arguments"
+	}
+	if targetX < 0 || targetX >= config.GridWidth || targetY < 0 || targetY >= config.GridHeight {
+		return "out_of_bounds"
+	}
+
+	nextX, nextY := loc.X, loc.Y
+	if abs(targetX-loc.X) >= abs(targetY-loc.Y) {
+		nextX += sign(targetX - loc.X)
+	} else {
+		nextY += sign(targetY - loc.Y)
+	}
+
+	if nextX == loc.X && nextY == loc.Y {
+		return "already_there"
+	}
+	if loc.Robot.Energy < moveEnergyCost {
+		return "not_enough_energy"
+	}
+	if grid[nextX][nextY].Robot != nil {
+		return "cell_occupied"
+	}
+
+	loc.Robot.Energy -= moveEnergyCost
+	grid[nextX][nextY].Robot = loc.Robot
+	grid[loc.X][loc.Y].Robot = nil
+	markDirty(loc.X, loc.Y)
+	markDirty(nextX, nextY)
+
+	loc.X, loc.Y = nextX, nextY
+	return ""
+}
+
+// HARVEST: draw the output of the power node the robot is standing on. Each
+// node gives up its output once per tick, however many orders ask for it.
+func harvest(loc *RobotLocation, harvested map[[2]int]bool) string {
+	node := grid[loc.X][loc.Y].PowerNode
+	if node == nil {
+		return "no_power_node"
+	}
+	if harvested[[2]int{loc.X, loc.Y}] {
+		return "already_harvested"
+	}
+	harvested[[2]int{loc.X, loc.Y}] = true
+	loc.Robot.Energy += node.EnergyProducedPerTick
+	markDirty(loc.X, loc.Y)
+	return ""
+}
+
+// REPAIR: restore the power link under the robot to full health
+func repairLink(loc *RobotLocation) string {
+	link := grid[loc.X][loc.Y].PowerLink
+	if link == nil {
+		return "no_power_link"
+	}
+	if link.Health >= linkMaxHealth {
+		return "already_repaired"
+	}
+	if loc.Robot.Energy < repairEnergyCost {
+		return "not_enough_energy"
+	}
+	loc.Robot.Energy -= repairEnergyCost
+	link.Health = linkMaxHealth
+	markDirty(loc.X, loc.Y)
+	return ""
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func sign(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	}
+	return 0
+}
+
+func clamp(n, low, high int) int {
+	if n < low {
+		return low
+	}
+	if n > high {
+		return high
+	}
+	return n
+}
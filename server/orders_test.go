@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestHarvestOncePerTick(t *testing.T) {
+	state := newTestWorld(t)
+	grid[4][4] = &GridCell{PowerNode: &PowerNode{EnergyProducedPerTick: 7}}
+	grid[4][4].Robot = &Robot{ID: 1, Owner: "p", Health: 100, Energy: 50}
+	state.Players["p"] = Player{ID: "p", Committed: []string{"HARVEST", "HARVEST", "1 HARVEST"}}
+
+	results := resolveOrders(state)["p"]
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	if !results[0].OK {
+		t.Errorf("first harvest failed: %s", results[0].Reason)
+	}
+	for _, result := range results[1:] {
+		if result.OK || result.Reason != "already_harvested" {
+			t.Errorf("repeat harvest %q: got ok=%v reason=%q, want already_harvested", result.Order, result.OK, result.Reason)
+		}
+	}
+	if energy := grid[4][4].Robot.Energy; energy != 57 {
+		t.Errorf("energy after the tick: got %d, want 57", energy)
+	}
+}
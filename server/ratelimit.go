@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ipLimiter   *rateLimiter // Every command from a remote IP
+	keyLimiter  *rateLimiter // Every command acting on a player
+	initLimiter *rateLimiter // INIT_PLAYER calls from a remote IP
+
+	connsPerIP = make(map[string]int) // Open connections per remote IP, guarded by mu
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Token buckets keyed by IP or player ID. A nil limiter allows everything.
+type rateLimiter struct {
+	mu        sync.Mutex
+	perSecond float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Take a token for key, reporting whether one was available
+func (l *rateLimiter) allow(key string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.pruneLocked(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.perSecond
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// Forget buckets that have refilled completely; they behave like new ones
+func (l *rateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.perSecond >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Build the limiters from config; a rate of 0 turns that limit off
+func initRateLimiters() {
+	ipLimiter = newRateLimiter(config.RateLimitPerIP, config.RateBurstPerIP)
+	keyLimiter = newRateLimiter(config.RateLimitPerKey, config.RateBurstPerKey)
+	initLimiter = newRateLimiter(config.InitPlayerPerMinute/60, config.InitPlayerBurst)
+}
+
+// The remote IP of a connection, without the port
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// Reserve a connection slot for an IP, failing once it holds too many
+func admitConnection(ip string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if config.MaxConnectionsPerIP > 0 && connsPerIP[ip] >= config.MaxConnectionsPerIP {
+		return false
+	}
+	connsPerIP[ip]++
+	return true
+}
+
+func releaseConnection(ip string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	connsPerIP[ip]--
+	if connsPerIP[ip] <= 0 {
+		delete(connsPerIP, ip)
+	}
+}
+
+// Charge a command against the limits that need only the connection,
+// returning which limit was hit, or "" if the command may go ahead. Runs on
+// the connection's goroutine so a flood never reaches the engine.
+func checkConnectionLimits(sess *Session, parts []string) string {
+	if !ipLimiter.allow(remoteIP(sess.Conn)) {
+		return "per_ip"
+	}
+
+	if parts[0] == "INIT_PLAYER" && !initLimiter.allow(remoteIP(sess.Conn)) {
+		return "init_player"
+	}
+
+	return ""
+}
+
+// Charge a command against the player it acts on, which only the engine can
+// resolve, returning "per_key" if that player is over the limit
+func checkKeyLimit(sess *Session, parts []string, state *GameState) string {
+	if playerID, _, ok := resolvePlayer(sess, parts[1:], state); ok && !keyLimiter.allow(playerID) {
+		return "per_key"
+	}
+
+	return ""
+}
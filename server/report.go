@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Build the report a player receives after a tick:
+//
+//	REPORT <tick> <robot count> <result count>
+//	ROBOT <id> <x> <y> <energy> <health>
+//	RESULT <index> OK|FAIL <reason or -> <robot id or -> <order>
+//	END REPORT
+func buildTickReport(tick int, robots []RobotLocation, results []OrderResult) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "REPORT %d %d %d\n", tick, len(robots), len(results))
+	for _, loc := range robots {
+		b.WriteString(robotLine(loc))
+	}
+	for i, result := range results {
+		status, reason, robotID := "OK", "-", "-"
+		if !result.OK {
+			status, reason = "FAIL", result.Reason
+		}
+		if result.RobotID != 0 {
+			robotID = fmt.Sprint(result.RobotID)
+		}
+		fmt.Fprintf(&b, "RESULT %d %s %s %s %s\n", i, status, reason, robotID, result.Order)
+	}
+	b.WriteString("END REPORT\n")
+
+	return b.String()
+}
+
+// ROBOT <id> <x> <y> <energy> <health>, shared by reports and STATUS
+func robotLine(loc RobotLocation) string {
+	return fmt.Sprintf("ROBOT %d %d %d %d %d\n", loc.Robot.ID, loc.X, loc.Y, loc.Robot.Energy, loc.Robot.Health)
+}
+
+// Push each authenticated player their own tick report
+func sendTickReports(state *GameState, results map[string][]OrderResult) {
+	robots := findRobots()
+
+	for _, playerID := range connectedPlayers() {
+		if _, exists := state.Players[playerID]; !exists {
+			continue
+		}
+		sendToPlayer(playerID, buildTickReport(state.Tick, robots[playerID], results[playerID]))
+	}
+}
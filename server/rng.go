@@ -0,0 +1,21 @@
+package main
+
+import "math/rand"
+
+// Gameplay randomness comes only from RNGs derived from the world seed, so a
+// world can be reproduced from its seed. Secrets such as API keys use
+// crypto/rand and never touch these.
+
+// The RNG the initial map is generated with
+func mapRand(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed))
+}
+
+// An RNG for one gameplay decision. It is derived from the seed and a counter
+// that advances with the world, such as the next robot ID, rather than kept
+// running, so the same decision comes out again after a restart or in a
+// replay.
+func decisionRand(seed int64, counter int) *rand.Rand {
+	const golden = -7046029254386353131 // 0x9E3779B97F4A7C15, spreads nearby counters apart
+	return rand.New(rand.NewSource(seed + int64(counter)*golden))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Describe the cells around one of the player's robots, charging the robot
+// the configured scan cost. Only non-empty layers are listed:
+//
+//	SCAN <robot id> <x> <y> <radius> <line count>
+//	SPAWN <x> <y> <cooldown until> <energy required>
+//	NODE <x> <y> <energy per tick>
+//	LINK <x> <y> <health>
+//	ROBOT <x> <y> <id> <health> <owner name>
+//	CORRUPTION <x> <y> <level>
+//	END SCAN
+//
+// CORRUPTION is reserved for when corruption is added to the world. The grid
+// doesn't model it yet, so no scan produces one.
+func scanAroundRobot(state *GameState, playerID string, args []string) (string, error) {
+	loc, _, reason := robotForOrder(findRobots()[playerID], args)
+	if loc == nil {
+		return "", fmt.Errorf("cannot scan: %s", reason)
+	}
+	if loc.Robot.Energy < config.ScanEnergyCost {
+		return "", fmt.Errorf("cannot scan: not_enough_energy")
+	}
+
+	loc.Robot.Energy -= config.ScanEnergyCost
+	markDirty(loc.X, loc.Y)
+
+	player := state.Players[playerID]
+	player.Stats.Scans++
+	state.Players[playerID] = player
+	markPlayerDirty(playerID)
+	recordEvent(CommandEvent{Tick: state.Tick + 1, Type: "scan", Player: playerID, Robot: loc.Robot.ID, Energy: config.ScanEnergyCost})
+
+	radius := config.ScanRadius
+	lines := make([]string, 0)
+	minX, maxX := clamp(loc.X-radius, 0, config.GridWidth-1), clamp(loc.X+radius, 0, config.GridWidth-1)
+	minY, maxY := clamp(loc.Y-radius, 0, config.GridHeight-1), clamp(loc.Y+radius, 0, config.GridHeight-1)
+	for x := minX; x <= maxX; x++ {
+		for y := minY; y <= maxY; y++ {
+			lines = append(lines, describeCell(state, x, y)...)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "SCAN %d %d %d %d %d\n", loc.Robot.ID, loc.X, loc.Y, radius, len(lines))
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("END SCAN\n")
+
+	return b.String(), nil
+}
+
+// One line per entity present in the cell
+func describeCell(state *GameState, x, y int) []string {
+	cell := grid[x][y]
+	if cell == nil {
+		return nil
+	}
+
+	lines := make([]string, 0)
+	if cell.Spawn != nil {
+		lines = append(lines, fmt.Sprintf("SPAWN %d %d %d %d", x, y, cell.Spawn.CooldownUntil, cell.Spawn.EnergyRequired))
+	}
+	if cell.PowerNode != nil {
+		lines = append(lines, fmt.Sprintf("NODE %d %d %d", x, y, cell.PowerNode.EnergyProducedPerTick))
+	}
+	if cell.PowerLink != nil {
+		lines = append(lines, fmt.Sprintf("LINK %d %d %d", x, y, cell.PowerLink.Health))
+	}
+	if cell.Robot != nil {
+		// Identify owners by name rather than their player ID
+		owner := state.Players[cell.Robot.Owner].Name
+		lines = append(lines, fmt.Sprintf("ROBOT %d %d %d %d %s", x, y, cell.Robot.ID, cell.Robot.Health, owner))
+	}
+	return lines
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+var errOutboundQueueFull = errors.New("outbound queue full")
+
+// Session tracks a single client connection and the player it authenticated as
+type Session struct {
+	Conn     net.Conn // Writes are queued and sent by the session's writer goroutine
+	PlayerID string   // Empty until AUTH or INIT_PLAYER succeeds on this connection
+
+	raw      net.Conn      // Underlying connection, written only by the writer goroutine
+	outbound chan []byte   // Messages waiting to be written
+	closed   chan struct{} // Closed along with the connection
+
+	closeOnce   sync.Once
+	closeReason string // Why the connection was closed, set by whoever closed it first
+	flushReason string // Reason to close with once the writer reaches the end-of-queue marker
+}
+
+// Connection wrapper whose writes go onto the session's outbound queue, so
+// broadcasting to a slow client never blocks the caller
+type queuedConn struct {
+	net.Conn
+	sess *Session
+}
+
+func (c *queuedConn) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil // A nil message marks the end of the queue
+	}
+
+	select {
+	case <-c.sess.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	// Callers may reuse their buffer once Write returns
+	message := append([]byte(nil), p...)
+
+	select {
+	case c.sess.outbound <- message:
+		return len(p), nil
+	default:
+	}
+
+	if config.SlowClientPolicy == "drop" {
+		log.Printf("Outbound queue full for %v; dropping %d byte message", c.RemoteAddr(), len(p))
+		return len(p), nil
+	}
+	closeSession(c.sess, "outbound queue full")
+	return 0, errOutboundQueueFull
+}
+
+// Drain a session's outbound queue onto the wire until the session closes
+func runSessionWriter(sess *Session) {
+	for {
+		select {
+		case message := <-sess.outbound:
+			if message == nil {
+				closeSession(sess, sess.flushReason)
+				return
+			}
+			if _, err := sess.raw.Write(message); err != nil {
+				closeSession(sess, "write failed: "+err.Error())
+				return
+			}
+		case <-sess.closed:
+			return
+		}
+	}
+}
+
+// Connection wrapper that puts a deadline on every write, so a client that
+// stops reading can't block the server indefinitely
+type deadlineConn struct {
+	net.Conn
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if config.WriteTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(time.Duration(config.WriteTimeout) * time.Second))
+	}
+	return c.Conn.Write(p)
+}
+
+// Close a session's connection, remembering the first reason given
+func closeSession(sess *Session, reason string) {
+	sess.closeOnce.Do(func() {
+		sess.closeReason = reason
+		close(sess.closed)
+		sess.raw.Close()
+	})
+}
+
+// Close a session once everything already queued for it has been written.
+// If the queue is full there is no room for the marker, so close right away.
+func closeSessionAfterFlush(sess *Session, reason string) {
+	sess.flushReason = reason // Published to the writer by the channel send
+	select {
+	case sess.outbound <- nil:
+	default:
+		closeSession(sess, reason)
+	}
+}
+
+// Why the session was closed. Only meaningful once closeSession has run.
+func sessionCloseReason(sess *Session) string {
+	sess.closeOnce.Do(func() {}) // Wait for a close in progress on another goroutine
+	return sess.closeReason
+}
+
+// Register a freshly accepted connection and start its writer. Writes must
+// go through the returned session's Conn from here on.
+func registerSession(conn net.Conn) *Session {
+	sess := &Session{
+		raw:      conn,
+		outbound: make(chan []byte, config.OutboundQueueSize),
+		closed:   make(chan struct{}),
+	}
+	sess.Conn = &queuedConn{Conn: conn, sess: sess}
+
+	mu.Lock()
+	conns[sess.Conn] = sess
+	mu.Unlock()
+
+	go runSessionWriter(sess)
+
+	return sess
+}
+
+// Forget a connection and any player binding it held
+func unregisterSession(sess *Session) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(conns, sess.Conn)
+	unbindSessionLocked(sess)
+}
+
+// Tie a session to a player so later commands can omit the API key
+func bindSession(sess *Session, playerID string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	unbindSessionLocked(sess)
+	sess.PlayerID = playerID
+
+	if playerConns[playerID] == nil {
+		playerConns[playerID] = make(map[net.Conn]*Session)
+	}
+	playerConns[playerID][sess.Conn] = sess
+
+	log.Printf("Connection %v authenticated as player %s", sess.Conn.RemoteAddr(), playerID)
+}
+
+// Caller must hold mu
+func unbindSessionLocked(sess *Session) {
+	if sess.PlayerID == "" {
+		return
+	}
+	if sessions, ok := playerConns[sess.PlayerID]; ok {
+		delete(sessions, sess.Conn)
+		if len(sessions) == 0 {
+			delete(playerConns, sess.PlayerID)
+		}
+	}
+	sess.PlayerID = ""
+}
+
+// The player ID this session is authenticated as, or "" if none
+func sessionPlayerID(sess *Session) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return sess.PlayerID
+}
+
+// Send a message to every connection authenticated as the given player
+func sendToPlayer(playerID string, message string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for conn, sess := range playerConns[playerID] {
+		if _, err := conn.Write([]byte(message)); err != nil {
+			log.Printf("Failed to send to player %s on %v: %v. Closing connection.", playerID, conn.RemoteAddr(), err)
+			closeSession(sess, "write failed: "+err.Error())
+		}
+	}
+}
+
+// Work out which player ID a command is for. An explicit API key as the first
+// argument still wins; otherwise the session's own identity is used.
+func resolvePlayer(sess *Session, args []string, state *GameState) (string, []string, bool) {
+	if len(args) > 0 {
+		if playerID, exists := playerForKey(state, args[0]); exists {
+			return playerID, args[1:], true
+		}
+	}
+
+	if playerID := sessionPlayerID(sess); playerID != "" {
+		return playerID, args, true
+	}
+
+	return "", args, false
+}
+
+// IDs of every player with at least one authenticated connection
+func connectedPlayers() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	playerIDs := make([]string, 0, len(playerConns))
+	for playerID := range playerConns {
+		playerIDs = append(playerIDs, playerID)
+	}
+	return playerIDs
+}
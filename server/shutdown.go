@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Block until the process is asked to stop, returning a reason for clients
+func waitForShutdownSignal() string {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	log.Printf("Received %v, shutting down...", sig)
+	return "signal " + sig.String()
+}
+
+// Stop the server without losing the tick in progress:
+//   - stop accepting connections on both ports
+//   - let the current tick finish and stop the tick loop
+//   - persist the game state and grid in one transaction
+//   - tell every client when to expect the server back, then disconnect them
+func shutdown(state *GameState, reason string, listener net.Listener, httpServer *http.Server, stopEngine chan<- struct{}) {
+	timeout := time.Duration(config.ShutdownTimeout) * time.Second
+
+	listener.Close()
+	httpCtx, cancel := context.WithTimeout(ctx, timeout)
+	if err := httpServer.Shutdown(httpCtx); err != nil {
+		log.Printf("HTTP server did not shut down cleanly: %v", err)
+	}
+	cancel()
+
+	// Once the engine has stopped, nothing else touches the world
+	close(stopEngine)
+	<-engineDone
+
+	if err := store.SaveWorld(state, grid, takePendingEvents()); err != nil {
+		log.Printf("Failed to persist world on shutdown: %v", err)
+	} else {
+		log.Printf("World persisted at tick %d.", state.Tick)
+	}
+
+	restartAt := int64(0)
+	if config.RestartDelay > 0 {
+		restartAt = time.Now().Add(time.Duration(config.RestartDelay) * time.Second).Unix()
+	}
+	notice := fmt.Sprintf("SHUTDOWN %d %s\n", restartAt, reason)
+
+	mu.Lock()
+	log.Printf("Notifying %d clients of shutdown.", len(conns))
+	for conn, sess := range conns {
+		conn.Write([]byte(notice))
+		closeSessionAfterFlush(sess, "server shutdown")
+	}
+	mu.Unlock()
+
+	// Give clients a moment to receive the notice before the process exits
+	finished := make(chan struct{})
+	go func() {
+		connWG.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		log.Println("All clients disconnected.")
+	case <-time.After(timeout):
+		log.Println("Timed out waiting for clients to disconnect.")
+	}
+}
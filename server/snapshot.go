@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// A full copy of the world after a tick. Only non-empty cells are kept.
+type worldSnapshot struct {
+	Tick  int            `json:"tick"`
+	State *GameState     `json:"state"`
+	Cells []snapshotCell `json:"cells"`
+}
+
+type snapshotCell struct {
+	X    int       `json:"x"`
+	Y    int       `json:"y"`
+	Cell *GridCell `json:"cell"`
+}
+
+// Whether any snapshots are retained at all
+func snapshotsEnabled() bool {
+	return config.SnapshotKeepRecent > 0 || config.SnapshotEvery > 0
+}
+
+// Save a snapshot of the world as it stands after this tick, then drop the
+// ones that have fallen out of the retention window
+func snapshotTick(state *GameState) {
+	if !snapshotsEnabled() {
+		return
+	}
+
+	data, err := json.Marshal(captureSnapshot(state))
+	if err != nil {
+		log.Printf("Failed to encode snapshot of tick %d: %v", state.Tick, err)
+		return
+	}
+	if err := store.SaveSnapshot(state.Tick, data); err != nil {
+		log.Printf("Failed to save snapshot of tick %d: %v", state.Tick, err)
+		return
+	}
+
+	pruneSnapshots(state.Tick)
+}
+
+// Copy the live world into a snapshot
+func captureSnapshot(state *GameState) worldSnapshot {
+	snapshot := worldSnapshot{Tick: state.Tick, State: state}
+	for x := range grid {
+		for y := range grid[x] {
+			if !isEmptyCell(grid[x][y]) {
+				snapshot.Cells = append(snapshot.Cells, snapshotCell{X: x, Y: y, Cell: grid[x][y]})
+			}
+		}
+	}
+	return snapshot
+}
+
+// Whether the snapshot of a tick is still kept once the world is at current:
+// the last SnapshotKeepRecent ticks, plus every SnapshotEvery-th tick
+func keepSnapshot(tick, current int) bool {
+	if current-tick < config.SnapshotKeepRecent {
+		return true
+	}
+	return config.SnapshotEvery > 0 && tick%config.SnapshotEvery == 0
+}
+
+func pruneSnapshots(current int) {
+	ticks, err := store.SnapshotTicks()
+	if err != nil {
+		log.Printf("Failed to list snapshots: %v", err)
+		return
+	}
+
+	for _, tick := range ticks {
+		if keepSnapshot(tick, current) {
+			continue
+		}
+		if err := store.DeleteSnapshot(tick); err != nil {
+			log.Printf("Failed to delete snapshot of tick %d: %v", tick, err)
+		}
+	}
+}
+
+// Replace the live world with the snapshot of an earlier tick. Snapshots
+// after it are discarded, the restored world is saved, and every client is
+// told with:
+//
+//	ROLLBACK <tick>
+//
+// Connections authenticated as players that did not exist yet at that tick
+// are signed out. Must only be called from the engine.
+func rollbackTo(state *GameState, tick int) error {
+	data, err := store.LoadSnapshot(tick)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return fmt.Errorf("no snapshot of tick %d", tick)
+	}
+
+	snapshot := &worldSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return fmt.Errorf("parsing snapshot of tick %d: %w", tick, err)
+	}
+
+	restored := newEmptyGrid(config.GridWidth, config.GridHeight)
+	for _, c := range snapshot.Cells {
+		if c.X < config.GridWidth && c.Y < config.GridHeight {
+			restored[c.X][c.Y] = c.Cell
+		}
+	}
+	if snapshot.State.Players == nil {
+		snapshot.State.Players = make(map[string]Player)
+	}
+
+	*state = *snapshot.State
+	grid = restored
+	// Everything is saved below
+	takeDirtyCells()
+	takeDirtyPlayers()
+
+	if err := truncateCommandLog(tick); err != nil {
+		return fmt.Errorf("truncating command log: %w", err)
+	}
+	if err := store.SaveWorld(state, grid, nil); err != nil {
+		return fmt.Errorf("saving restored world: %w", err)
+	}
+
+	// Later snapshots describe a timeline that no longer happened
+	if ticks, err := store.SnapshotTicks(); err == nil {
+		for _, t := range ticks {
+			if t > tick {
+				store.DeleteSnapshot(t)
+			}
+		}
+	}
+
+	log.Printf("Rolled the game back to tick %d.", tick)
+
+	mu.Lock()
+	defer mu.Unlock()
+	message := fmt.Sprintf("ROLLBACK %d\n", tick)
+	for conn, sess := range conns {
+		if _, exists := state.Players[sess.PlayerID]; sess.PlayerID != "" && !exists {
+			unbindSessionLocked(sess)
+		}
+		conn.Write([]byte(message))
+	}
+	return nil
+}
+
+// Ticks that can currently be rolled back to, oldest first
+func listSnapshots() ([]int, error) {
+	ticks, err := store.SnapshotTicks()
+	if err != nil {
+		return nil, err
+	}
+	sort.Ints(ticks)
+	return ticks, nil
+}
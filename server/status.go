@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Summarise a player's robots and orders:
+//
+//	STATUS <name> <tick> <robot count> <staged count> <committed count>
+//	ROBOT <id> <x> <y> <energy> <health>
+//	END STATUS
+func buildStatus(state *GameState, playerID string) string {
+	player := state.Players[playerID]
+	robots := findRobots()[playerID]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "STATUS %s %d %d %d %d\n", player.Name, state.Tick, len(robots), len(player.Commands), len(player.Committed))
+	for _, loc := range robots {
+		b.WriteString(robotLine(loc))
+	}
+	b.WriteString("END STATUS\n")
+
+	return b.String()
+}
+
+// List the staged commands with the index CANCEL expects:
+//
+//	QUEUE <count>
+//	STAGED <index> <command>
+//	END QUEUE
+func buildQueue(state *GameState, playerID string) string {
+	player := state.Players[playerID]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "QUEUE %d\n", len(player.Commands))
+	for i, command := range player.Commands {
+		fmt.Fprintf(&b, "STAGED %d %s\n", i, command)
+	}
+	b.WriteString("END QUEUE\n")
+
+	return b.String()
+}
+
+// Drop one staged command by index, or all of them with ALL. Returns the
+// number of commands removed.
+func cancelCommands(state *GameState, playerID string, which string) (int, error) {
+	player := state.Players[playerID]
+
+	if which == "ALL" {
+		cancelled := len(player.Commands)
+		player.Commands = []string{}
+		state.Players[playerID] = player
+		return cancelled, nil
+	}
+
+	index, err := strconv.Atoi(which)
+	if err != nil || index < 0 || index >= len(player.Commands) {
+		return 0, fmt.Errorf("no staged command at index %s", which)
+	}
+
+	commands := make([]string, 0, len(player.Commands)-1)
+	commands = append(commands, player.Commands[:index]...)
+	commands = append(commands, player.Commands[index+1:]...)
+	player.Commands = commands
+	state.Players[playerID] = player
+	return 1, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"sync"
+)
+
+// Storage persists the world between restarts. The game state is saved as a
+// small set of world values (tick, next robot ID, seed, key salt) with each
+// player as a record of its own, so a tick only rewrites the players it
+// changed; in Redis these are world:meta and the player:<id> keys.
+type Storage interface {
+	// The saved game state, or nil if none has been saved yet
+	LoadGameState() (*GameState, error)
+	SaveGameState(state *GameState) error
+
+	// Whether a grid has been saved yet
+	GridInitialized() (bool, error)
+	LoadGrid(width, height int) ([][]*GridCell, error)
+	// Save every cell and mark the grid as initialized
+	SaveGrid(grid [][]*GridCell) error
+
+	// Save the game state and the whole grid in one atomic write, appending
+	// the given events to the command log. Unfinished tick journals are
+	// discarded, since the saved world supersedes them.
+	SaveWorld(state *GameState, grid [][]*GridCell, events [][]byte) error
+	// Save what a tick changed in one atomic write
+	CommitTick(state *GameState, grid [][]*GridCell, changes TickChanges) error
+	// Finish saving a tick that was interrupted part way through, returning
+	// its number, or 0 if there was nothing to recover
+	RecoverTick() (int, error)
+
+	// Encoded world snapshots, keyed by tick. LoadSnapshot returns nil if
+	// there is no snapshot of that tick.
+	SaveSnapshot(tick int, data []byte) error
+	LoadSnapshot(tick int) ([]byte, error)
+	SnapshotTicks() ([]int, error)
+	DeleteSnapshot(tick int) error
+
+	// The encoded command log, oldest first
+	LoadEvents() ([][]byte, error)
+	// Keep only the first keep entries of the command log
+	TruncateEvents(keep int) error
+
+	// Bring stored data up to the current schema, or with dryRun set, only
+	// report what that would change
+	Migrate(dryRun bool) error
+	// The number of migrations the stored data still needs
+	PendingMigrations() (int, error)
+
+	// Delete everything
+	Reset() error
+}
+
+// Everything changed since the last tick commit
+type TickChanges struct {
+	Cells   [][2]int // Positions of changed cells
+	Players []string // IDs of changed players
+	Events  [][]byte // Encoded events to append to the command log
+}
+
+var (
+	store Storage
+
+	dirtyMu      sync.Mutex
+	dirtyCells   = make(map[[2]int]struct{}) // Cells changed since the last save
+	dirtyPlayers = make(map[string]struct{}) // Players changed since the last save
+)
+
+// Connect to the storage backend named in config and migrate its data
+func initStorage() {
+	openStorage()
+	if err := store.Migrate(false); err != nil {
+		log.Fatalf("Failed to migrate stored data: %v", err)
+	}
+}
+
+// Connect to the storage backend for a tool that only reads the world, which
+// must not migrate it either
+func openStorageReadOnly() {
+	openStorage()
+	pending, err := store.PendingMigrations()
+	if err != nil {
+		log.Fatalf("Failed to check the stored data's schema: %v", err)
+	}
+	if pending > 0 {
+		log.Fatalf("The stored data needs %d migration(s); run the migrate subcommand first.", pending)
+	}
+}
+
+// Connect to the storage backend named in config
+func openStorage() {
+	switch config.Storage {
+	case "redis":
+		redisStore, err := newRedisStorage(config.RedisAddr)
+		if err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+		store = redisStore
+		log.Println("Connected to Redis.")
+	case "memory":
+		store = newMemoryStorage()
+		log.Println("Using in-memory storage; the world will not survive a restart.")
+	default:
+		log.Fatalf("Unknown storage backend %q", config.Storage)
+	}
+}
+
+// The migrate subcommand: apply pending migrations, or with -dry-run only
+// report what they would change, then exit
+func runMigrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would change without writing anything")
+	flags.Parse(args)
+
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	openStorage()
+	if err := store.Migrate(*dryRun); err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+}
+
+// Record that a cell changed and needs saving at the end of the tick
+func markDirty(x, y int) {
+	dirtyMu.Lock()
+	defer dirtyMu.Unlock()
+	dirtyCells[[2]int{x, y}] = struct{}{}
+}
+
+// Take the set of changed cells, leaving it empty
+func takeDirtyCells() [][2]int {
+	dirtyMu.Lock()
+	defer dirtyMu.Unlock()
+
+	positions := make([][2]int, 0, len(dirtyCells))
+	for pos := range dirtyCells {
+		positions = append(positions, pos)
+	}
+	dirtyCells = make(map[[2]int]struct{})
+	return positions
+}
+
+// Record that a player changed and needs saving at the end of the tick
+func markPlayerDirty(playerID string) {
+	dirtyMu.Lock()
+	defer dirtyMu.Unlock()
+	dirtyPlayers[playerID] = struct{}{}
+}
+
+// Take the set of changed players, leaving it empty
+func takeDirtyPlayers() []string {
+	dirtyMu.Lock()
+	defer dirtyMu.Unlock()
+
+	playerIDs := make([]string, 0, len(dirtyPlayers))
+	for playerID := range dirtyPlayers {
+		playerIDs = append(playerIDs, playerID)
+	}
+	dirtyPlayers = make(map[string]struct{})
+	return playerIDs
+}
+
+// Save the tick counter together with the cells and players changed since
+// the last commit and the events logged since then, as one atomic write. On
+// failure the changes are queued again so the next tick retries them.
+func commitTick(state *GameState) {
+	changes := TickChanges{
+		Cells:   takeDirtyCells(),
+		Players: takeDirtyPlayers(),
+		Events:  takePendingEvents(),
+	}
+
+	if err := store.CommitTick(state, grid, changes); err != nil {
+		log.Printf("Failed to commit tick %d: %v", state.Tick, err)
+		for _, pos := range changes.Cells {
+			markDirty(pos[0], pos[1])
+		}
+		for _, playerID := range changes.Players {
+			markPlayerDirty(playerID)
+		}
+		requeueEvents(changes.Events)
+		return
+	}
+	log.Printf("Committed tick %d with %d changed cells, %d changed players and %d logged events.",
+		state.Tick, len(changes.Cells), len(changes.Players), len(changes.Events))
+}
+
+// Finish a tick whose save was interrupted, before the world is loaded
+func recoverPartialTick() {
+	tick, err := store.RecoverTick()
+	if err != nil {
+		log.Fatalf("Failed to recover partially saved tick: %v", err)
+	}
+	if tick > 0 {
+		log.Printf("Recovered tick %d, which was interrupted while being saved.", tick)
+	}
+}
+
+// Allocate a grid of empty cells
+func newEmptyGrid(width, height int) [][]*GridCell {
+	newGrid := make([][]*GridCell, width)
+	for x := 0; x < width; x++ {
+		newGrid[x] = make([]*GridCell, height)
+		for y := 0; y < height; y++ {
+			newGrid[x][y] = &GridCell{}
+		}
+	}
+	return newGrid
+}
+
+func isEmptyCell(cell *GridCell) bool {
+	return cell == nil || (cell.Spawn == nil && cell.PowerNode == nil && cell.PowerLink == nil && cell.Robot == nil)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Storage that keeps everything in process memory, for tests and offline
+// play. Saved values are copied so later changes to the live world don't
+// leak into what was saved.
+type memoryStorage struct {
+	mu              sync.Mutex
+	state           []byte               // JSON encoded game state, nil until saved
+	cells           map[[2]int]*GridCell // Non-empty cells by position
+	snapshots       map[int][]byte       // Encoded world snapshots by tick
+	events          [][]byte             // Encoded command log
+	gridInitialized bool
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		cells:     make(map[[2]int]*GridCell),
+		snapshots: make(map[int][]byte),
+	}
+}
+
+func (s *memoryStorage) LoadGameState() (*GameState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == nil {
+		return nil, nil
+	}
+	state := &GameState{}
+	if err := json.Unmarshal(s.state, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *memoryStorage) SaveGameState(state *GameState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = data
+	return nil
+}
+
+func (s *memoryStorage) GridInitialized() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gridInitialized, nil
+}
+
+func (s *memoryStorage) LoadGrid(width, height int) ([][]*GridCell, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loadedGrid := newEmptyGrid(width, height)
+	for pos, cell := range s.cells {
+		if pos[0] < width && pos[1] < height {
+			loadedGrid[pos[0]][pos[1]] = copyCell(cell)
+		}
+	}
+	return loadedGrid, nil
+}
+
+func (s *memoryStorage) SaveGrid(grid [][]*GridCell) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.saveGridLocked(grid)
+	return nil
+}
+
+func (s *memoryStorage) SaveWorld(state *GameState, grid [][]*GridCell, events [][]byte) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = data
+	s.cells = make(map[[2]int]*GridCell)
+	s.saveGridLocked(grid)
+	s.events = append(s.events, events...)
+	return nil
+}
+
+// The state is small enough in memory to save whole, so changes.Players is
+// not needed
+func (s *memoryStorage) CommitTick(state *GameState, grid [][]*GridCell, changes TickChanges) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = data
+	for _, pos := range changes.Cells {
+		s.saveCellLocked(pos[0], pos[1], grid[pos[0]][pos[1]])
+	}
+	s.events = append(s.events, changes.Events...)
+	return nil
+}
+
+// Commits happen under a single lock, so no tick is ever left half saved
+func (s *memoryStorage) RecoverTick() (int, error) {
+	return 0, nil
+}
+
+func (s *memoryStorage) SaveSnapshot(tick int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshots[tick] = data
+	return nil
+}
+
+func (s *memoryStorage) LoadSnapshot(tick int) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.snapshots[tick], nil
+}
+
+func (s *memoryStorage) SnapshotTicks() ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ticks := make([]int, 0, len(s.snapshots))
+	for tick := range s.snapshots {
+		ticks = append(ticks, tick)
+	}
+	return ticks, nil
+}
+
+func (s *memoryStorage) DeleteSnapshot(tick int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.snapshots, tick)
+	return nil
+}
+
+func (s *memoryStorage) LoadEvents() ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([][]byte(nil), s.events...), nil
+}
+
+func (s *memoryStorage) TruncateEvents(keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if keep < len(s.events) {
+		s.events = s.events[:keep]
+	}
+	return nil
+}
+
+// Nothing outlives the process, so there is never old data to migrate
+func (s *memoryStorage) Migrate(dryRun bool) error {
+	return nil
+}
+
+func (s *memoryStorage) PendingMigrations() (int, error) {
+	return 0, nil
+}
+
+func (s *memoryStorage) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = nil
+	s.cells = make(map[[2]int]*GridCell)
+	s.snapshots = make(map[int][]byte)
+	s.events = nil
+	s.gridInitialized = false
+	return nil
+}
+
+func (s *memoryStorage) saveGridLocked(grid [][]*GridCell) {
+	for x := range grid {
+		for y := range grid[x] {
+			s.saveCellLocked(x, y, grid[x][y])
+		}
+	}
+	s.gridInitialized = true
+}
+
+func (s *memoryStorage) saveCellLocked(x, y int, cell *GridCell) {
+	if isEmptyCell(cell) {
+		delete(s.cells, [2]int{x, y})
+		return
+	}
+	s.cells[[2]int{x, y}] = copyCell(cell)
+}
+
+// Deep copy of a cell and every layer in it
+func copyCell(cell *GridCell) *GridCell {
+	copied := &GridCell{}
+	if cell.Spawn != nil {
+		spawn := *cell.Spawn
+		copied.Spawn = &spawn
+	}
+	if cell.PowerNode != nil {
+		node := *cell.PowerNode
+		copied.PowerNode = &node
+	}
+	if cell.PowerLink != nil {
+		link := *cell.PowerLink
+		copied.PowerLink = &link
+	}
+	if cell.Robot != nil {
+		robot := *cell.Robot
+		copied.Robot = &robot
+	}
+	return copied
+}
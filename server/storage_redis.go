@@ -0,0 +1,539 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Storage backed by Redis:
+//   - world:meta is a small hash with the tick counter, next robot ID, map
+//     seed and API key salt; its tick is the last one whose writes were
+//     applied in full
+//   - each player is a JSON record under player:<player id>, and the set
+//     players lists their IDs
+//   - each non-empty cell is a hash under grid:x:y with one JSON encoded
+//     field per layer, so a robot standing on a spawn keeps both
+//   - commandlog is a list of JSON encoded events
+type redisStorage struct {
+	client *redis.Client
+}
+
+// The values kept in world:meta
+type worldMeta struct {
+	Tick        int    `json:"tick"`
+	NextRobotID int    `json:"next_robot_id"`
+	Seed        int64  `json:"seed"`
+	KeySalt     string `json:"key_salt"`
+}
+
+// Everything one tick writes. It is stored under tick:<n>:journal before being
+// applied, so a commit interrupted before EXEC can be finished on restart.
+type tickJournal struct {
+	Tick    int                        `json:"tick"`
+	Meta    worldMeta                  `json:"meta"`
+	Players map[string]json.RawMessage `json:"players"` // Changed players by player ID
+	Cells   map[string]*GridCell       `json:"cells"`   // Keyed by "x:y"
+	Events  []json.RawMessage          `json:"events"`
+}
+
+// Hash fields of a layered cell, matching the GridCell JSON names
+const (
+	layerSpawn     = "spawn"
+	layerPowerNode = "power_node"
+	layerPowerLink = "power_link"
+	layerRobot     = "robot"
+)
+
+func newRedisStorage(addr string) (*redisStorage, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr: addr,
+	})
+	if _, err := client.Ping(ctx).Result(); err != nil {
+		return nil, err
+	}
+
+	return &redisStorage{client: client}, nil
+}
+
+func (s *redisStorage) LoadGameState() (*GameState, error) {
+	meta, err := s.client.HGetAll(ctx, "world:meta").Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) == 0 {
+		return nil, nil
+	}
+
+	seed, _ := strconv.ParseInt(meta["seed"], 10, 64)
+	state := &GameState{
+		Tick:        atoi(meta["tick"]),
+		NextRobotID: atoi(meta["next_robot_id"]),
+		Seed:        seed,
+		KeySalt:     meta["key_salt"],
+		Players:     make(map[string]Player),
+	}
+
+	playerIDs, err := s.client.SMembers(ctx, "players").Result()
+	if err != nil {
+		return nil, err
+	}
+	pipe := s.client.Pipeline()
+	records := make([]*redis.StringCmd, len(playerIDs))
+	for i, playerID := range playerIDs {
+		records[i] = pipe.Get(ctx, playerKey(playerID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("loading players: %w", err)
+	}
+
+	for i, playerID := range playerIDs {
+		data, err := records[i].Bytes()
+		if err == redis.Nil {
+			log.Printf("Player %s is listed but has no record; skipping.", playerID)
+			continue
+		}
+		var player Player
+		if err := json.Unmarshal(data, &player); err != nil {
+			return nil, fmt.Errorf("parsing player %s: %w", playerID, err)
+		}
+		state.Players[playerID] = player
+	}
+	return state, nil
+}
+
+// Rewrite world:meta and every player record
+func (s *redisStorage) SaveGameState(state *GameState) error {
+	pipe := s.client.TxPipeline()
+	if err := s.queueStateWrite(pipe, state); err != nil {
+		return err
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func playerKey(playerID string) string {
+	return "player:" + playerID
+}
+
+func metaOf(state *GameState) worldMeta {
+	return worldMeta{Tick: state.Tick, NextRobotID: state.NextRobotID, Seed: state.Seed, KeySalt: state.KeySalt}
+}
+
+func queueMetaWrite(pipe redis.Pipeliner, meta worldMeta) {
+	pipe.HSet(ctx, "world:meta", "tick", meta.Tick, "next_robot_id", meta.NextRobotID, "seed", meta.Seed, "key_salt", meta.KeySalt)
+}
+
+func queuePlayerWrite(pipe redis.Pipeliner, playerID string, data []byte) {
+	pipe.Set(ctx, playerKey(playerID), data, 0)
+	pipe.SAdd(ctx, "players", playerID)
+}
+
+// Queue the writes that replace the whole game state, removing the records
+// of players it no longer has
+func (s *redisStorage) queueStateWrite(pipe redis.Pipeliner, state *GameState) error {
+	stored, err := s.client.SMembers(ctx, "players").Result()
+	if err != nil {
+		return err
+	}
+	for _, playerID := range stored {
+		if _, exists := state.Players[playerID]; !exists {
+			pipe.Del(ctx, playerKey(playerID))
+			pipe.SRem(ctx, "players", playerID)
+		}
+	}
+
+	queueMetaWrite(pipe, metaOf(state))
+	for playerID, player := range state.Players {
+		data, err := json.Marshal(player)
+		if err != nil {
+			return err
+		}
+		queuePlayerWrite(pipe, playerID, data)
+	}
+	return nil
+}
+
+func (s *redisStorage) GridInitialized() (bool, error) {
+	exists, err := s.client.Exists(ctx, "grid-initialized").Result()
+	return exists > 0, err
+}
+
+func (s *redisStorage) LoadGrid(width, height int) ([][]*GridCell, error) {
+	loadedGrid := newEmptyGrid(width, height) // Empty cells aren't stored in Redis
+
+	iter := s.client.Scan(ctx, 0, "grid:*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+
+		var x, y int
+		_, err := fmt.Sscanf(key, "grid:%d:%d", &x, &y)
+		if err != nil {
+			log.Printf("Failed to parse grid coordinates from key %s: %v", key, err)
+			continue
+		}
+
+		cellData, err := s.client.HGetAll(ctx, key).Result()
+		if err != nil {
+			log.Printf("Failed to load cell data from Redis for %s: %v", key, err)
+			continue
+		}
+
+		cell, err := cellFromRedisHash(cellData)
+		if err != nil {
+			log.Printf("Failed to decode cell %s: %v", key, err)
+			continue
+		}
+
+		loadedGrid[x][y] = cell
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterating through Redis keys: %w", err)
+	}
+
+	return loadedGrid, nil
+}
+
+// Save every non-empty cell in one pipeline
+func (s *redisStorage) SaveGrid(grid [][]*GridCell) error {
+	pipe := s.client.Pipeline()
+	for x := range grid {
+		for y := range grid[x] {
+			if !isEmptyCell(grid[x][y]) {
+				queueCellWrite(pipe, x, y, grid[x][y])
+			}
+		}
+	}
+	pipe.Set(ctx, "grid-initialized", 1, 0)
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Queue the commands that replace a cell's hash, so layers that have left the
+// cell don't linger. Empty cells have their key removed.
+func queueCellWrite(pipe redis.Pipeliner, x, y int, cell *GridCell) {
+	key := fmt.Sprintf("grid:%d:%d", x, y)
+	pipe.Del(ctx, key)
+	if !isEmptyCell(cell) {
+		pipe.HSet(ctx, key, cellToRedisHash(cell))
+	}
+}
+
+// Write the game state and every grid cell in a single MULTI/EXEC, so the
+// stored tick counter, players and grid always agree. Tick journals left by
+// failed commits are dropped with it; after a rollback they belong to a
+// timeline that no longer happened, and RecoverTick would replay them.
+func (s *redisStorage) SaveWorld(state *GameState, grid [][]*GridCell, events [][]byte) error {
+	journals, err := s.client.Keys(ctx, "tick:*:journal").Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := s.client.TxPipeline()
+	if err := s.queueStateWrite(pipe, state); err != nil {
+		return err
+	}
+	if len(journals) > 0 {
+		pipe.Del(ctx, journals...)
+	}
+	for x := range grid {
+		for y := range grid[x] {
+			queueCellWrite(pipe, x, y, grid[x][y])
+		}
+	}
+	pipe.Set(ctx, "grid-initialized", 1, 0)
+	queueEventAppend(pipe, events)
+
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func queueEventAppend(pipe redis.Pipeliner, events [][]byte) {
+	if len(events) == 0 {
+		return
+	}
+	values := make([]interface{}, len(events))
+	for i, event := range events {
+		values[i] = []byte(event)
+	}
+	pipe.RPush(ctx, "commandlog", values...)
+}
+
+// Journal the tick, then apply it in a single MULTI/EXEC that also advances
+// the tick in world:meta and drops the journal. Only the players and cells
+// the tick changed are written.
+func (s *redisStorage) CommitTick(state *GameState, grid [][]*GridCell, changes TickChanges) error {
+	journal := &tickJournal{
+		Tick:    state.Tick,
+		Meta:    metaOf(state),
+		Players: make(map[string]json.RawMessage),
+		Cells:   make(map[string]*GridCell),
+	}
+	for _, playerID := range changes.Players {
+		player, exists := state.Players[playerID]
+		if !exists {
+			continue
+		}
+		data, err := json.Marshal(player)
+		if err != nil {
+			return err
+		}
+		journal.Players[playerID] = data
+	}
+	for _, pos := range changes.Cells {
+		journal.Cells[fmt.Sprintf("%d:%d", pos[0], pos[1])] = grid[pos[0]][pos[1]]
+	}
+	for _, event := range changes.Events {
+		journal.Events = append(journal.Events, event)
+	}
+	journalData, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+
+	journalKey := fmt.Sprintf("tick:%d:journal", state.Tick)
+	if err := s.client.Set(ctx, journalKey, journalData, 0).Err(); err != nil {
+		return fmt.Errorf("journaling tick: %w", err)
+	}
+
+	return s.applyJournal(journalKey, journal)
+}
+
+func (s *redisStorage) applyJournal(journalKey string, journal *tickJournal) error {
+	pipe := s.client.TxPipeline()
+	queueMetaWrite(pipe, journal.Meta)
+	for playerID, data := range journal.Players {
+		queuePlayerWrite(pipe, playerID, data)
+	}
+	for pos, cell := range journal.Cells {
+		var x, y int
+		if _, err := fmt.Sscanf(pos, "%d:%d", &x, &y); err != nil {
+			return fmt.Errorf("bad cell position %q: %w", pos, err)
+		}
+		queueCellWrite(pipe, x, y, cell)
+	}
+	events := make([][]byte, len(journal.Events))
+	for i, event := range journal.Events {
+		events[i] = event
+	}
+	queueEventAppend(pipe, events)
+	pipe.Del(ctx, journalKey)
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Apply any journal newer than the tick in world:meta; its commit never
+// reached EXEC. Older journals were already applied and are dropped.
+func (s *redisStorage) RecoverTick() (int, error) {
+	appliedTick, err := s.client.HGet(ctx, "world:meta", "tick").Int()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	keys, err := s.client.Keys(ctx, "tick:*:journal").Result()
+	if err != nil {
+		return 0, err
+	}
+	journals := make([]*tickJournal, 0, len(keys))
+	for _, key := range keys {
+		data, err := s.client.Get(ctx, key).Bytes()
+		if err != nil {
+			return 0, fmt.Errorf("reading %s: %w", key, err)
+		}
+		journal := &tickJournal{}
+		if err := json.Unmarshal(data, journal); err != nil {
+			return 0, fmt.Errorf("parsing %s: %w", key, err)
+		}
+		journals = append(journals, journal)
+	}
+	sort.Slice(journals, func(i, j int) bool { return journals[i].Tick < journals[j].Tick })
+
+	recovered := 0
+	for _, journal := range journals {
+		key := fmt.Sprintf("tick:%d:journal", journal.Tick)
+		if journal.Tick <= appliedTick {
+			if err := s.client.Del(ctx, key).Err(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := s.applyJournal(key, journal); err != nil {
+			return 0, fmt.Errorf("applying %s: %w", key, err)
+		}
+		appliedTick, recovered = journal.Tick, journal.Tick
+	}
+	return recovered, nil
+}
+
+// Snapshots live under snapshot:<tick>, with their ticks in the sorted set
+// snapshots so they can be listed without scanning keys
+func (s *redisStorage) SaveSnapshot(tick int, data []byte) error {
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, fmt.Sprintf("snapshot:%d", tick), data, 0)
+	pipe.ZAdd(ctx, "snapshots", &redis.Z{Score: float64(tick), Member: tick})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStorage) LoadSnapshot(tick int) ([]byte, error) {
+	data, err := s.client.Get(ctx, fmt.Sprintf("snapshot:%d", tick)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *redisStorage) SnapshotTicks() ([]int, error) {
+	members, err := s.client.ZRange(ctx, "snapshots", 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ticks := make([]int, 0, len(members))
+	for _, member := range members {
+		ticks = append(ticks, atoi(member))
+	}
+	return ticks, nil
+}
+
+func (s *redisStorage) DeleteSnapshot(tick int) error {
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, fmt.Sprintf("snapshot:%d", tick))
+	pipe.ZRem(ctx, "snapshots", tick)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStorage) LoadEvents() ([][]byte, error) {
+	entries, err := s.client.LRange(ctx, "commandlog", 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([][]byte, len(entries))
+	for i, entry := range entries {
+		events[i] = []byte(entry)
+	}
+	return events, nil
+}
+
+func (s *redisStorage) TruncateEvents(keep int) error {
+	if keep <= 0 {
+		return s.client.Del(ctx, "commandlog").Err()
+	}
+	return s.client.LTrim(ctx, "commandlog", 0, int64(keep-1)).Err()
+}
+
+// Wipe the database, leaving it marked as the current schema version
+func (s *redisStorage) Reset() error {
+	if err := s.client.FlushDB(ctx).Err(); err != nil {
+		return err
+	}
+	return s.client.Set(ctx, "schema-version", len(redisMigrations), 0).Err()
+}
+
+// Flatten a cell into the hash stored under grid:x:y, one field per layer
+func cellToRedisHash(cell *GridCell) map[string]interface{} {
+	data := make(map[string]interface{})
+
+	// Layers are plain structs of ints and strings, so encoding can't fail
+	if cell.Spawn != nil {
+		encoded, _ := json.Marshal(cell.Spawn)
+		data[layerSpawn] = string(encoded)
+	}
+	if cell.PowerNode != nil {
+		encoded, _ := json.Marshal(cell.PowerNode)
+		data[layerPowerNode] = string(encoded)
+	}
+	if cell.PowerLink != nil {
+		encoded, _ := json.Marshal(cell.PowerLink)
+		data[layerPowerLink] = string(encoded)
+	}
+	if cell.Robot != nil {
+		encoded, _ := json.Marshal(cell.Robot)
+		data[layerRobot] = string(encoded)
+	}
+
+	return data
+}
+
+// Rebuild a cell from its layered hash. Hashes still in the legacy
+// single-type format are decoded as well, so loading works mid-migration.
+func cellFromRedisHash(data map[string]string) (*GridCell, error) {
+	if _, legacy := data["type"]; legacy {
+		return legacyCellFromRedisHash(data), nil
+	}
+
+	cell := &GridCell{}
+	layers := map[string]interface{}{
+		layerSpawn:     &cell.Spawn,
+		layerPowerNode: &cell.PowerNode,
+		layerPowerLink: &cell.PowerLink,
+		layerRobot:     &cell.Robot,
+	}
+	for field, target := range layers {
+		encoded, ok := data[field]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal([]byte(encoded), target); err != nil {
+			return nil, fmt.Errorf("decoding %s layer: %w", field, err)
+		}
+	}
+
+	return cell, nil
+}
+
+// Decode a cell in the old single-type format. The old server wrote a robot
+// onto its spawn's hash and later re-wrote the spawn without clearing it, so
+// one hash may hold the fields of several layers whatever its type says; each
+// layer is rebuilt from whichever of its fields are present. Robots saved
+// before they had IDs come back with ID 0.
+func legacyCellFromRedisHash(cellData map[string]string) *GridCell {
+	cell := &GridCell{}
+	has := func(fields ...string) bool {
+		for _, field := range fields {
+			if _, ok := cellData[field]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if has("cooldown_until", "cooldown_amount", "energy_required") {
+		cell.Spawn = &Spawn{
+			CooldownUntil:  atoi(cellData["cooldown_until"]),
+			CooldownAmount: atoi(cellData["cooldown_amount"]),
+			EnergyRequired: atoi(cellData["energy_required"]),
+		}
+	}
+	if has("energy_produced_per_tick") {
+		cell.PowerNode = &PowerNode{
+			EnergyProducedPerTick: atoi(cellData["energy_produced_per_tick"]),
+		}
+	}
+	if has("built_by") {
+		cell.PowerLink = &PowerLink{
+			BuiltBy: cellData["built_by"],
+			Health:  atoi(cellData["health"]),
+		}
+	}
+	if has("owner", "energy", "queued_action") {
+		cell.Robot = &Robot{
+			ID:           atoi(cellData["id"]),
+			Owner:        cellData["owner"],
+			Health:       atoi(cellData["health"]),
+			Energy:       atoi(cellData["energy"]),
+			QueuedAction: cellData["queued_action"],
+		}
+	}
+
+	return cell
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+// A journal left by a commit that failed after tick 5 must not be replayed
+// onto a world rolled back to tick 5
+func TestSaveWorldDropsStaleJournals(t *testing.T) {
+	s := newTestRedis(t, map[string]string{
+		"tick:7:journal": `{"tick":7,"meta":{"tick":7,"next_robot_id":9},"players":{},"cells":{},"events":[]}`,
+	}, nil)
+
+	state := &GameState{Tick: 5, Players: make(map[string]Player), NextRobotID: 2}
+	if err := s.SaveWorld(state, newEmptyGrid(4, 4), nil); err != nil {
+		t.Fatalf("save world: %v", err)
+	}
+
+	recovered, err := s.RecoverTick()
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	if recovered != 0 {
+		t.Errorf("recovered tick %d from a discarded timeline", recovered)
+	}
+	if saved, _ := s.LoadGameState(); saved == nil || saved.Tick != 5 || saved.NextRobotID != 2 {
+		t.Errorf("got state %+v, want the saved tick 5", saved)
+	}
+}
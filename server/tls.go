@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// Whether a certificate and key have been configured
+func tlsEnabled() bool {
+	return config.TLSCertFile != "" && config.TLSKeyFile != ""
+}
+
+// Refuse TLS settings that would otherwise be silently ignored, leaving a
+// port plaintext or open to clients without a certificate
+func checkTLSSettings() error {
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return fmt.Errorf("tls_cert_file and tls_key_file must be set together")
+	}
+	if config.TLSRequireClientCert && config.TLSClientCAFile == "" {
+		return fmt.Errorf("tls_require_client_cert needs tls_client_ca_file to verify certificates against")
+	}
+	if config.TLSClientCAFile != "" && !tlsEnabled() {
+		return fmt.Errorf("tls_client_ca_file needs tls_cert_file and tls_key_file")
+	}
+	return nil
+}
+
+// Build the TLS configuration for a listener, or nil when TLS is off and the
+// listener should stay plaintext. Client certificates are only checked when
+// withClientAuth is set and a client CA has been configured.
+func loadTLSConfig(withClientAuth bool) (*tls.Config, error) {
+	if !tlsEnabled() {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if withClientAuth && config.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(config.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading TLS client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.TLSClientCAFile)
+		}
+
+		tlsConfig.ClientCAs = pool
+		if config.TLSRequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return tlsConfig, nil
+}
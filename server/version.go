@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	serverVersion = "0.1.0"
+	// Bump whenever a verb, response or push format changes incompatibly
+	protocolVersion = 2
+)
+
+// Every verb parseCommand understands, advertised to clients on connect
+var supportedVerbs = []string{
+	"HELP",
+	"VERSION",
+	"PING",
+	"PONG",
+	"INIT_PLAYER",
+	"AUTH",
+	"SCAN",
+	"LOOK",
+	"STATUS",
+	"QUEUE",
+	"CANCEL",
+	"COMMAND",
+	"COMMIT",
+	"ADMIN",
+}
+
+// Greeting sent when a client connects and in reply to VERSION:
+//
+//	HELLO SurgeProtocol server=<version> protocol=<version> tick_duration=<seconds, may be fractional> grid=<width>x<height>
+//	VERBS <verb> <verb> ...
+func buildBanner() string {
+	return fmt.Sprintf("HELLO SurgeProtocol server=%s protocol=%d tick_duration=%g grid=%dx%d\nVERBS %s\n",
+		serverVersion, protocolVersion, config.TickDuration, config.GridWidth, config.GridHeight,
+		strings.Join(supportedVerbs, " "))
+}
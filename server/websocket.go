@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/websocket"
+)
+
+// A WebSocket connection reports the page origin as its remote address, so
+// carry the real peer address from the HTTP request instead
+type wsConn struct {
+	*websocket.Conn
+	remote net.Addr
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Register the WebSocket gateway on the HTTP server. Each text message is one
+// command, handled exactly like a line on the TCP listener, and TICK pushes and
+// reports arrive as messages. Sessions and players are shared with TCP clients.
+func serveWebSocketGateway() {
+	http.Handle(config.WebSocketPath, websocket.Handler(func(ws *websocket.Conn) {
+		remote, err := net.ResolveTCPAddr("tcp", ws.Request().RemoteAddr)
+		if err != nil {
+			log.Printf("Rejecting WebSocket client with bad address %q: %v", ws.Request().RemoteAddr, err)
+			return
+		}
+		connWG.Add(1)
+		handleConnection(&wsConn{Conn: ws, remote: remote})
+	}))
+
+	log.Printf("WebSocket gateway registered at %s", config.WebSocketPath)
+}